@@ -0,0 +1,222 @@
+package bio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"errors"
+	"fmt"
+)
+
+// Compressions of the fasta files. The value is the extension of the compressed files
+const (
+	NOCOMPRESSION	= "none"
+	GZIP			= "gz"
+	BZIP2			= "bz2"
+	ZSTD			= "zst"
+)
+
+// File name of the standard input (reading) or the standard output (writting)
+const STDIO = "-"
+
+var (
+	gzipMagic	= []byte{0x1f, 0x8b}
+	bzip2Magic	= []byte("BZh")
+	zstdMagic	= []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Compression of a file by the extension of its name (e.g. "seqs.fa.gz")
+func CompressionByName(fileName string) string {
+
+	for _, c := range []string{GZIP, BZIP2, ZSTD} {
+		if strings.HasSuffix(fileName, "." + c) {
+			return c
+		}
+	}
+
+	return NOCOMPRESSION
+}
+
+// Whether the files can be written with the compression (bzip2 is only supported for reading)
+func CheckCompression(compression string) error {
+
+	switch compression {
+
+	case NOCOMPRESSION, GZIP, ZSTD:
+		return nil
+
+	case BZIP2:
+		return errors.New("bzip2 compression is only supported for reading. Use gz or zst")
+
+	}
+
+	return errors.New(fmt.Sprintf("Unrecognized compression: %s (valid: %s, %s, %s)", compression,
+		NOCOMPRESSION, GZIP, ZSTD))
+}
+
+// File and the decompressor reading from it, closed together
+type readCloser struct {
+	io.Reader
+	closers		[]func() error
+}
+
+func (rc *readCloser) Close() error {
+
+	var err error
+
+	for _, c := range rc.closers {
+		if cerr := c(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Decompress the data of the reader, detecting the compression by its first bytes (magic number) or,
+// if they are not known, by the extension of the file name
+func decompress(r io.Reader, fileName string) (io.Reader, func() error, error) {
+
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	compression := CompressionByName(fileName)
+
+	switch {
+
+	case bytes.HasPrefix(magic, gzipMagic):
+		compression = GZIP
+
+	case bytes.HasPrefix(magic, bzip2Magic):
+		compression = BZIP2
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		compression = ZSTD
+
+	// Not compressed, whatever the extension says (e.g. an empty file)
+	case len(magic) > 0 && magic[0] == HEADER:
+		compression = NOCOMPRESSION
+
+	}
+
+	noClose := func() error { return nil }
+
+	switch compression {
+
+	case GZIP:
+		gz, err := gzip.NewReader(br)
+
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Invalid gzip file %s: %s", fileName, err))
+		}
+
+		return gz, gz.Close, nil
+
+	case BZIP2:
+		return bzip2.NewReader(br), noClose, nil
+
+	case ZSTD:
+		zr, err := zstd.NewReader(br)
+
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Invalid zstd file %s: %s", fileName, err))
+		}
+
+		return zr, func() error { zr.Close(); return nil }, nil
+
+	}
+
+	return br, noClose, nil
+}
+
+// Open a fasta file, compressed (gzip, bzip2 or zstd) or not. The file "-" is the standard input
+func OpenFile(fileName string) (io.ReadCloser, error) {
+
+	var fin io.ReadCloser = ioutil.NopCloser(os.Stdin)
+
+	if fileName != STDIO {
+
+		var err error
+		fin, err = os.Open(fileName)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r, closeFn, err := decompress(fin, fileName)
+
+	if err != nil {
+		fin.Close()
+		return nil, err
+	}
+
+	return &readCloser{Reader: r, closers: []func() error{closeFn, fin.Close}}, nil
+}
+
+// File and the compressor writing to it. Closing it flushes the compressor before closing the file
+type writeCloser struct {
+	io.Writer
+	closers		[]func() error
+}
+
+func (wc *writeCloser) Close() error {
+
+	var err error
+
+	for _, c := range wc.closers {
+		if cerr := c(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Create a file compressed as its extension says (".gz" or ".zst"), or not compressed.
+// The file "-" is the standard output (not compressed), which is not closed
+func CreateFile(fileName string) (io.WriteCloser, error) {
+
+	if fileName == STDIO {
+		return &writeCloser{Writer: os.Stdout}, nil
+	}
+
+	compression := CompressionByName(fileName)
+	err := CheckCompression(compression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fout, err := os.Create(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch compression {
+
+	case GZIP:
+		gz := gzip.NewWriter(fout)
+
+		return &writeCloser{Writer: gz, closers: []func() error{gz.Close, fout.Close}}, nil
+
+	case ZSTD:
+		zw, err := zstd.NewWriter(fout)
+
+		if err != nil {
+			fout.Close()
+			return nil, err
+		}
+
+		return &writeCloser{Writer: zw, closers: []func() error{zw.Close, fout.Close}}, nil
+
+	}
+
+	return fout, nil
+}
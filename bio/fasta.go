@@ -4,17 +4,18 @@ import (
 	"sync"
 	"bufio"
 	"io"
-	"strings"
 	"fmt"
-	"os"
 	. "bitbucket.org/germelcar/campred/common"
 	"errors"
+	"sort"
 )
 
 type FastaSeq struct {
 
-	ID		string
+	ID		string	// Up to the first space of the header, without ">"
+	Desc	string	// Rest of the header (if any)
 	Seq		string
+	Num		int		// One based position in the input file. It travels with the sequence through the splitted files
 }
 
 type FastaFile struct {
@@ -29,9 +30,19 @@ func (f *FastaSeq) Len() int {
 	return len(f.Seq)
 }
 
+// Full header of the sequence, as in the original file
+func (f FastaSeq) Header() string {
+
+	if f.Desc == "" {
+		return string(HEADER) + f.ID
+	}
+
+	return string(HEADER) + f.ID + " " + f.Desc
+}
+
 func (f FastaSeq) Write(wrt *bufio.Writer) error {
 
-	_, err := wrt.WriteString(fmt.Sprintf("%s\n%s\n", f.ID, f.Seq))
+	_, err := wrt.WriteString(fmt.Sprintf("%s\n%s\n", f.Header(), f.Seq))
 
 	if err != nil {
 		return err
@@ -40,32 +51,96 @@ func (f FastaSeq) Write(wrt *bufio.Writer) error {
 	return nil
 }
 
-func WriteSeqs(outFile string, fseqs []FastaSeq) {
+// Write the sequence with only its ID in the header
+func (f FastaSeq) WriteID(wrt *bufio.Writer) error {
+
+	_, err := wrt.WriteString(fmt.Sprintf("%c%s\n%s\n", HEADER, f.ID, f.Seq))
+
+	return err
+}
+
+// Write the sequence with its ID and its number in the input file (see NUMTAG) in the header
+func (f FastaSeq) WriteNum(wrt *bufio.Writer) error {
+
+	_, err := wrt.WriteString(fmt.Sprintf("%c%s %s%d\n%s\n", HEADER, f.ID, NUMTAG, f.Num, f.Seq))
 
-	fout, err := os.Create(outFile)
-	wrt := bufio.NewWriter(fout)
+	return err
+}
+
+// Write the sequences in a file. Only the IDs are written, as in the requests sent to the server, along with the number
+// of each sequence in the input file, so the splitted files can be read in any order
+//
+// The file is compressed if its name has the extension of a compression (see CreateFile)
+func WriteFile(outFile string, fseqs []FastaSeq) error {
 
-	defer fout.Close()
-	defer wrt.Flush()
+	fout, err := CreateFile(outFile)
 
 	if err != nil {
-		ErrorLog.Println(err)
-		os.Exit(1)
+		return err
 	}
 
+	wrt := NewWriter(fout)
+	wrt.WithNum = true
+
 	for _, f := range fseqs {
-		err = f.Write(wrt)
+		err = wrt.Write(f)
 
 		if err != nil {
-			ErrorLog.Printf("Error while writting splitted sequences to file %s: %s\n", outFile, err)
-			os.Exit(1)
+			fout.Close()
+			return errors.New(fmt.Sprintf("Error while writting splitted sequences to file %s: %s", outFile, err))
 		}
 	}
+
+	err = wrt.Flush()
+
+	if err != nil {
+		fout.Close()
+		return err
+	}
+
+	return fout.Close()
 }
 
-func SplitFasta(inFile, outFile string, numSeqs int, verbose bool) ([]FastaFile, int, error) {
+// Splitted files that SplitFasta would write for a file with "totSeqs" sequences, without writting them.
+// As in SplitFasta, "numSeqs" of 1 means that all the sequences go together
+func PlanChunks(outFile string, totSeqs, numSeqs int, compression string) []FastaFile {
 
-	fin, err := os.Open(inFile)
+	if numSeqs <= 1 {
+		numSeqs = totSeqs
+	}
+
+	files := []FastaFile{}
+
+	for n := 1; totSeqs > 0; n++ {
+
+		chunkSeqs := numSeqs
+
+		if totSeqs < numSeqs {
+			chunkSeqs = totSeqs
+		}
+
+		files = append(files, FastaFile{ChunkName(outFile, n, compression), chunkSeqs})
+		totSeqs -= chunkSeqs
+	}
+
+	return files
+}
+
+// Splitted file written and its position (one based) in the input file
+type splitFile struct {
+	idx		int
+	ff		FastaFile
+}
+
+// Split the input file in files of "numSeqs" sequences (at most) each one, writting up to "numThreads"
+// files concurrently. The files are returned in the same order of the sequences in the input file.
+// "numSeqs" of 0 means that all the sequences go together.
+//
+// Only the sequences accepted by the validator (if any) are written, with the given compression
+func SplitFasta(inFile, outFile string, numSeqs, numThreads int, compression string, v *Validator,
+		verbose bool) ([]FastaFile, int, error) {
+
+	fin, err := OpenFile(inFile)
 
 	if err != nil {
 		return nil, 0, err
@@ -74,26 +149,23 @@ func SplitFasta(inFile, outFile string, numSeqs int, verbose bool) ([]FastaFile,
 	defer fin.Close()
 
 	// File reader and channel for keeping those "splitted" files that were successful splitted
-	rdr := bufio.NewReader(fin)
-	outWritten := make(chan *FastaFile, 10)
+	// and channel for limiting the files written concurrently
+	rdr := NewReader(fin)
+	rdr.Validator = v
+	outWritten := make(chan splitFile, 10)
+	limitCh := make(chan bool, numThreads)
 
 	// Total output/splitted files
 	// Fasta sequences to split
 	// Splitted filenames
-	var totOutFiles 	= 1
+	var totOutFiles 	int
 	var totSeqs 		int
 	fseqs := 			[]FastaSeq{}
-	outFastaFiles :=	[]FastaFile{}
+	written :=			[]splitFile{}
 
 	// Waigroup for the goroutines that will write the sequences
-	// id, seq, and filename of the splitted sequences
-	// number of line reading
 	var wg 		sync.WaitGroup
 	var wgC		sync.WaitGroup
-	var id 		string
-	var seq 	string
-	var fname	string
-	numLine := 1
 
 	// One time arrived here, means that the splitted file was written successful
 	// Keep waiting and processing (appending to the slice) all the splitted filenames.
@@ -101,84 +173,71 @@ func SplitFasta(inFile, outFile string, numSeqs int, verbose bool) ([]FastaFile,
 	go func() {
 		defer wgC.Done()
 
-		for ff := range outWritten {
-			outFastaFiles = append(outFastaFiles, *ff)
+		for sf := range outWritten {
+			written = append(written, sf)
 		}
 
 	}()
 
-	READSEQS:
-	for {
+	// Send to write the sequences in the file. If everything OK, then
+	// "inform" to the channel that such file was written, otherwise,
+	// put a warning on the screen
+	writeChunk := func(fs []FastaSeq) {
 
-		// Read up to (and including) the new line character (\n)
-		line, err := rdr.ReadBytes(NEWLINE)
+		totOutFiles++
+		totSeqs += len(fs)
+		limitCh <- true
+		wg.Add(1)
 
-		// If there was an error and if the error is EOF, break loop, otherwise, return the error
-		if err != nil {
-			if err == io.EOF {
-				break READSEQS
-			}
-
-			return nil, 0, errors.New(fmt.Sprintf("Error while reading line %d: %s", numLine, err))
-		}
+		go func (idx int, ofile string, fs []FastaSeq) {
+			defer func() {
+				<-limitCh
+			}()
+			defer wg.Done()
 
-		// Convert slice of bytes to string before of the new line character and remove spaces on the left side but
-		// including the possible ">" character from the line as the ID of the fasta sequence
-		sline := strings.TrimLeft(string(line[ : len(line) - 1]), " ")
+			totFs := len(fs)
+			err := WriteFile(ofile, fs)
 
-		if sline[0] == '>' {
-
-			spaceIdx := strings.Index(sline, " ")
-
-			if len(id) > 0 {
-				fseqs = append(fseqs, FastaSeq{id, seq})
-				seq = ""
-				id = ""
-
-				if len(fseqs) == numSeqs {
-
-					wg.Add(1)
-					fname = outFile + "_" + fmt.Sprint(totOutFiles) + ".fasta"
-					totOutFiles++
-					totSeqs += numSeqs
-
-					// Send to write the sequences in the file. If everything OK, then
-					// "inform" to the channel that such file was written, otherwise,
-					// put a warning on the screen
-					go func (ofile string, fs []FastaSeq) {
-						defer wg.Done()
+			if err != nil {
+				WarningLog.Println(err)
+				return
+			}
 
-						totFs := len(fs)
-						WriteSeqs(ofile, fs)
-						outWritten <- &FastaFile{ofile, totFs}
+			outWritten <- splitFile{idx, FastaFile{ofile, totFs}}
 
-						if verbose {
-							InfoLog.Printf("Splitted %d sequences in file: %s\n", totFs, ofile)
-						}
+			if verbose {
+				InfoLog.Printf("Splitted %d sequences in file: %s\n", totFs, ofile)
+			}
 
-					}(fname, fseqs)
+		}(totOutFiles, ChunkName(outFile, totOutFiles, compression), fs)
+	}
 
-					fseqs = []FastaSeq{}
+	var readErr error
 
-				} // End of if len(fseqs)...
+	for {
 
-			} // End of if len(id) > 0...
+		fs, err := rdr.Next()
 
-			// If "spaceIdx == 1", then the ID sequence has no spaces and then
-			// include all the line, otherwise, keep up to a character before the space
-			if spaceIdx == -1 {
-				id += sline
-			} else {
-				id += sline[ : spaceIdx]
+		if err != nil {
+			if err != io.EOF {
+				readErr = err
 			}
 
-		} else { // if-else sline[0] == '>'...
-			seq += sline
+			break
 		}
 
-		numLine++
+		fseqs = append(fseqs, fs)
 
-	} // End of for... reading lines
+		if len(fseqs) == numSeqs {
+			writeChunk(fseqs)
+			fseqs = []FastaSeq{}
+		}
+	}
+
+	// Write the last set of sequences (if any)
+	if len(fseqs) > 0 {
+		writeChunk(fseqs)
+	}
 
 	// Wait all goroutines to finish and close the channel
 	// only the goroutines are "using" the channel. One time the goroutines have finished
@@ -187,186 +246,236 @@ func SplitFasta(inFile, outFile string, numSeqs int, verbose bool) ([]FastaFile,
 	close(outWritten)
 	wgC.Wait()
 
-	// Add the last sequence
-	if seq != "" && id != "" {
-		fseqs = append(fseqs, FastaSeq{id, seq})
+	// The files are written concurrently, so they could have finished in any order
+	sort.Slice(written, func(i, j int) bool {
+		return written[i].idx < written[j].idx
+	})
+
+	outFastaFiles := []FastaFile{}
+
+	for _, sf := range written {
+		outFastaFiles = append(outFastaFiles, sf.ff)
 	}
 
-	// Writte the last set of sequences. If "numSeqs" is equal to the total number of sequences
-	// in the file, then, fseqs contains all the sequences of the file. In this case, write the left sequences
-	fname = outFile + "_" + fmt.Sprint(totOutFiles) + ".fasta"
-	totFs := len(fseqs)
-	totSeqs += totFs
-	WriteSeqs(fname, fseqs)
-	outFastaFiles = append(outFastaFiles, FastaFile{fname, totFs})
+	if readErr != nil {
+		return outFastaFiles, totSeqs, readErr
+	}
 
-	if verbose {
-		InfoLog.Printf("Splitted %d sequences in file: %s", totFs, fname)
+	if len(outFastaFiles) != totOutFiles {
+		return outFastaFiles, totSeqs, errors.New(fmt.Sprintf("%d of %d splitted files written",
+			len(outFastaFiles), totOutFiles))
 	}
 
 	return outFastaFiles, totSeqs, nil // all OK
 }
 
-func StatFasta(inFile string) (FastaFile, error) {
+// Count the sequences of the file accepted by the validator (all of them without validator)
+func StatFasta(inFile string, v *Validator) (FastaFile, error) {
 
-	fin, err := os.Open(inFile)
+	fin, err := OpenFile(inFile)
 
 	if err != nil {
 		return FastaFile{}, err
 	}
 
 	defer fin.Close()
-	rdr := bufio.NewReader(fin)
-	var id	string
-	var seq string
-	numLine := 1
+	rdr := NewReader(fin)
+	rdr.Validator = v
 	numSeqs := 0
 
-	READSEQS:
 	for {
-		line, err := rdr.ReadBytes(NEWLINE)
 
-		if err != nil {
-			if err == io.EOF {
-				break READSEQS
-			}
+		_, err := rdr.Next()
 
-			return FastaFile{}, errors.New(fmt.Sprintf("Error while reading line %d: %s", numLine, err))
+		if err == io.EOF {
+			break
 		}
 
-		sline := strings.TrimLeft(string(line[ : len(line) - 1]), " ")
+		if err != nil {
+			return FastaFile{}, err
+		}
 
-		if sline[0] == '>' {
+		numSeqs++
+	}
 
-			if len(id) > 0 {
-				numSeqs++
-				id = ""
+	return FastaFile{FileName:inFile, NumSeqs:numSeqs}, nil
+}
 
-			}
+// Call "fn" for every sequence of the file (in order). The number of the sequence
+// is one based, the same numbering used for the predictions.
+func EachSeq(inFile string, fn func(numSeq int, fs FastaSeq) error) error {
 
-			id = "."
+	fin, err := OpenFile(inFile)
 
-		} else {
-			seq = "."
+	if err != nil {
+		return err
+	}
+
+	defer fin.Close()
+	rdr := NewReader(fin)
+
+	for numSeq := 1; ; numSeq++ {
+
+		fs, err := rdr.Next()
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
 		}
 
-		numLine++
+		err = fn(numSeq, fs)
+
+		if err != nil {
+			return err
+		}
 	}
+}
 
-	if seq != "" && id != "" {
-		numSeqs++
+// Name of the n-th (one based) splitted file, with the extension of its compression (if any)
+func ChunkName(outFile string, n int, compression string) string {
+
+	if compression != NOCOMPRESSION && compression != "" {
+		return outFile + "_" + fmt.Sprint(n) + ".fasta." + compression
 	}
 
-	return FastaFile{FileName:inFile, NumSeqs:numSeqs}, nil
+	return outFile + "_" + fmt.Sprint(n) + ".fasta"
 }
 
-func ExtractSeqs(inFile, outFile string, seqs map[int]struct{}, verbose bool) error {
+// Read all the sequences of the file
+func ReadSeqs(inFile string) ([]FastaSeq, error) {
 
-	totSeqs := len(seqs)
-	fin, err := os.Open(inFile)
-	defer fin.Close()
+	fseqs := []FastaSeq{}
+
+	err := EachSeq(inFile, func(numSeq int, fs FastaSeq) error {
+		fseqs = append(fseqs, fs)
+		return nil
+	})
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	fout, err := os.Create(outFile)
+	return fseqs, nil
+}
 
-	if err != nil {
-		return err
-	}
+// Write the sequences of the input file whose number (one based) is in "seqs", with their full header,
+// compressed if the name of the output file has the extension of a compression.
+// The ID of every sequence must be the one given in "seqs", otherwise the results do not belong
+// to this file (or it has changed) and nothing should be extracted by its position
+func ExtractSeqs(inFile, outFile string, seqs map[int]string, verbose bool) error {
 
-	rdr := bufio.NewReader(fin)
-	wrt := bufio.NewWriter(fout)
-	defer wrt.Flush()
+	return extractSeqs(inFile, []string{outFile}, seqs, func(numSeq int) []int {
+		return []int{0}
+	}, verbose)
+}
 
-	totWritten := 0
-	numSeq := 1
-	numLine := 1
-	var id	string
-	var seq string
+// As ExtractSeqs, but the sequences of each sample are also written to their own file (see SampleFileName)
+func ExtractSamples(inFile, outFile string, samples []Sample, seqs map[int]string, verbose bool) error {
 
+	outFiles := []string{outFile}
 
-	READSEQS:
-	for {
+	for _, s := range samples {
+		outFiles = append(outFiles, SampleFileName(outFile, s.Name))
+	}
 
-		line, err := rdr.ReadBytes(NEWLINE)
+	return extractSeqs(inFile, outFiles, seqs, func(numSeq int) []int {
 
-		if err != nil {
-			if err == io.EOF {
-				break READSEQS
+		for i := range samples {
+			if samples[i].Has(numSeq) {
+				return []int{0, i + 1}
 			}
-
-			return errors.New(fmt.Sprintf("Error while reading line %d: %s", numLine, err))
 		}
 
-		sline := strings.TrimLeft(string(line[ : len(line) - 1]), " ")
+		return []int{0}
+	}, verbose)
+}
 
-		if sline[0] == '>' {
+// Write every sequence in "seqs" to the output files given by "outsOf" (their positions in "outFiles")
+func extractSeqs(inFile string, outFiles []string, seqs map[int]string, outsOf func(numSeq int) []int,
+	verbose bool) error {
 
-			spaceIdx := strings.Index(sline, " ")
+	totSeqs := len(seqs)
+	fouts := []io.WriteCloser{}
+	wrts := []*Writer{}
 
-			if len(id) > 0 {
+	closeAll := func() {
+		for _, fout := range fouts {
+			fout.Close()
+		}
+	}
 
-				fs := FastaSeq{id, seq}
-				_, ok := seqs[numSeq]
-				id = ""
-				seq = ""
+	for _, outFile := range outFiles {
 
-				if ok {
+		fout, err := CreateFile(outFile)
 
-					err := fs.Write(wrt)
+		if err != nil {
+			closeAll()
+			return err
+		}
 
-					if err != nil {
-						return errors.New(fmt.Sprintf("%s: %s", "Unable to extract sequence", err))
-					} else {
-						totWritten++
+		fouts = append(fouts, fout)
+		wrts = append(wrts, NewWriter(fout))
+	}
 
-						if verbose {
-							fmt.Printf("[%d/%d] sequences extracted\r", totWritten, totSeqs)
-						}
-					}
+	totWritten := 0
 
-				}
+	err := EachSeq(inFile, func(numSeq int, fs FastaSeq) error {
 
-				numSeq++
+		id, ok := seqs[numSeq]
 
-			} // End of len(id) > 0...
+		if !ok {
+			return nil
+		}
+
+		if id != fs.ID {
+			return errors.New(fmt.Sprintf("Sequence %d of %s is %s, but the results are for %s",
+				numSeq, inFile, fs.ID, id))
+		}
+
+		for _, i := range outsOf(numSeq) {
+
+			err := wrts[i].Write(fs)
 
-			if spaceIdx == -1 {
-				id += sline
-			} else {
-				id += sline[ : spaceIdx]
+			if err != nil {
+				return errors.New(fmt.Sprintf("%s: %s", "Unable to extract sequence", err))
 			}
+		}
+
+		totWritten++
 
-		} else { // End of if-else sline[0] == '>'...
-			seq += sline
+		if verbose {
+			fmt.Fprintf(Console, "[%d/%d] sequences extracted\r", totWritten, totSeqs)
 		}
 
-		numLine++
+		return nil
+	})
 
+	if err != nil {
+		closeAll()
+		return err
 	}
 
-	if seq != "" && id != "" {
-
-		_, ok := seqs[numSeq]
+	for i, wrt := range wrts {
 
-		if ok {
-			fs := FastaSeq{id, seq}
-			err := fs.Write(wrt)
+		err = wrt.Flush()
 
-			if err != nil {
-				return errors.New(fmt.Sprintf("%s: %s", "Unable to extract sequence", err))
-			} else {
-				totWritten++
+		if err == nil {
+			// Closing a compressed file writes its last block
+			err = fouts[i].Close()
+		} else {
+			fouts[i].Close()
+		}
 
-				if verbose {
-					fmt.Printf("[%d/%d] sequences extracted\r", totWritten, totSeqs)
-				}
+		if err != nil {
+			for _, fout := range fouts[i + 1:] {
+				fout.Close()
 			}
 
+			return errors.New(fmt.Sprintf("%s: %s", "Unable to extract sequence", err))
 		}
-
 	}
 
 	if totWritten != totSeqs {
@@ -374,4 +483,57 @@ func ExtractSeqs(inFile, outFile string, seqs map[int]struct{}, verbose bool) er
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// Write the sequences given by "fn" for every sequence of the input file (e.g. its peptides or its windows).
+// Returns the number of sequences written for each sequence read (see RemapSamples) and their total
+// The file is compressed if its name has the extension of a compression (see CreateFile)
+func transformFasta(inFile, outFile, what string, verbose bool, fn func(FastaSeq) []FastaSeq) ([]int, int, error) {
+
+	fout, err := CreateFile(outFile)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	wrt := NewWriter(fout)
+	numOut := []int{}
+	totOut := 0
+
+	err = EachSeq(inFile, func(numSeq int, fs FastaSeq) error {
+
+		outSeqs := fn(fs)
+
+		for _, ofs := range outSeqs {
+
+			err := wrt.Write(ofs)
+
+			if err != nil {
+				return errors.New(fmt.Sprintf("Error while writting %s to file %s: %s", what, outFile, err))
+			}
+		}
+
+		numOut = append(numOut, len(outSeqs))
+		totOut += len(outSeqs)
+
+		if verbose {
+			fmt.Fprintf(Console, "[%d] sequences read (%d %s)\r", numSeq, totOut, what)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		fout.Close()
+		return numOut, totOut, err
+	}
+
+	err = wrt.Flush()
+
+	if err != nil {
+		fout.Close()
+		return numOut, totOut, err
+	}
+
+	return numOut, totOut, fout.Close()
+}
@@ -0,0 +1,205 @@
+package bio
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"strconv"
+	"errors"
+	"fmt"
+)
+
+const (
+	HEADER	= '>'
+	COMMENT	= ';'
+	NUMTAG	= "campred_num="	// Number of the sequence in the input file, in the headers of the splitted files
+)
+
+// Reader of the sequences of a fasta file, one at a time, so the file does not need to fit in memory.
+//
+// Blank lines and comment lines (starting with ";") are skipped, the lines can end with "\n" or "\r\n"
+// and the last line does not need a new line character.
+//
+// The sequences are numbered in the order of the file, unless their header has the number
+// of the sequence in the input file (see WriteNum).
+//
+// With a Validator, only the sequences that can be sent are returned (stripped if needed)
+type Reader struct {
+	Validator	*Validator
+
+	rdr		*bufio.Reader
+	numLine	int
+	numSeqs	int
+	id		string	// ID, description and number of the next sequence (its header was already read)
+	desc	string
+	num		int
+	started	bool
+	eof		bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{rdr: bufio.NewReader(r)}
+}
+
+// Next line of the file without the new line characters. At the end of the file, the error is io.EOF
+func (r *Reader) readLine() (string, error) {
+
+	// Read up to (and including) the new line character (\n)
+	line, err := r.rdr.ReadString(NEWLINE)
+
+	if err != nil {
+
+		// The last line could have no new line character
+		if err == io.EOF && len(line) > 0 {
+			r.numLine++
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+
+		if err == io.EOF {
+			return "", io.EOF
+		}
+
+		return "", errors.New(fmt.Sprintf("Error while reading line %d: %s", r.numLine + 1, err))
+	}
+
+	r.numLine++
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Next sequence of the file. At the end of the file, the error is io.EOF
+func (r *Reader) Next() (FastaSeq, error) {
+
+	for {
+
+		fs, err := r.next()
+
+		if err != nil || r.Validator == nil {
+			return fs, err
+		}
+
+		fs, ok, err := r.Validator.Check(fs)
+
+		if err != nil {
+			return FastaSeq{}, err
+		}
+
+		if ok {
+			return fs, nil
+		}
+	}
+}
+
+func (r *Reader) next() (FastaSeq, error) {
+
+	var seq strings.Builder
+
+	for !r.eof {
+
+		line, err := r.readLine()
+
+		if err == io.EOF {
+			r.eof = true
+			break
+		}
+
+		if err != nil {
+			return FastaSeq{}, err
+		}
+
+		sline := strings.TrimSpace(line)
+
+		if sline == "" || sline[0] == COMMENT {
+			continue
+		}
+
+		if sline[0] == HEADER {
+
+			// If the header has spaces, the ID is up to a character before the first space
+			// and the description is the rest
+			id := sline[1 : ]
+			desc := ""
+
+			if spaceIdx := strings.IndexAny(id, " \t"); spaceIdx != -1 {
+				desc = strings.TrimSpace(id[spaceIdx : ])
+				id = id[ : spaceIdx]
+			}
+
+			if id == "" {
+				return FastaSeq{}, errors.New(fmt.Sprintf("Header without ID at line %d", r.numLine))
+			}
+
+			r.numSeqs++
+			num := r.numSeqs
+
+			if strings.HasPrefix(desc, NUMTAG) {
+
+				n, err := strconv.Atoi(desc[len(NUMTAG) : ])
+
+				if err != nil || n < 1 {
+					return FastaSeq{}, errors.New(fmt.Sprintf("Wrong sequence number (%s) at line %d", desc, r.numLine))
+				}
+
+				num, desc = n, ""
+			}
+
+			// The header of the next sequence ends the current one
+			if r.started {
+				fs := FastaSeq{ID: r.id, Desc: r.desc, Seq: seq.String(), Num: r.num}
+				r.id, r.desc, r.num = id, desc, num
+				return fs, nil
+			}
+
+			r.id, r.desc, r.num = id, desc, num
+			r.started = true
+
+		} else {
+
+			if !r.started {
+				return FastaSeq{}, errors.New(fmt.Sprintf("Sequence without header at line %d", r.numLine))
+			}
+
+			seq.WriteString(sline)
+		}
+	}
+
+	// Last sequence of the file
+	if r.started {
+		fs := FastaSeq{ID: r.id, Desc: r.desc, Seq: seq.String(), Num: r.num}
+		r.started = false
+		return fs, nil
+	}
+
+	return FastaSeq{}, io.EOF
+}
+
+// Writer of fasta sequences. With IDOnly, the descriptions of the headers are not written.
+// With WithNum, the descriptions are replaced by the number of the sequences (see NUMTAG)
+type Writer struct {
+	IDOnly	bool
+	WithNum	bool
+
+	wrt		*bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{wrt: bufio.NewWriter(w)}
+}
+
+func (w *Writer) Write(fs FastaSeq) error {
+
+	if w.WithNum {
+		return fs.WriteNum(w.wrt)
+	}
+
+	if w.IDOnly {
+		return fs.WriteID(w.wrt)
+	}
+
+	return fs.Write(w.wrt)
+}
+
+// Write the buffered sequences. Must be called once all the sequences have been written
+func (w *Writer) Flush() error {
+	return w.wrt.Flush()
+}
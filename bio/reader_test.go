@@ -0,0 +1,117 @@
+package bio
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// All the sequences of the text, or the error that stops the reader
+func readAll(text string) ([]FastaSeq, error) {
+
+	rdr := NewReader(strings.NewReader(text))
+	seqs := []FastaSeq{}
+
+	for {
+
+		fs, err := rdr.Next()
+
+		if err == io.EOF {
+			return seqs, nil
+		}
+
+		if err != nil {
+			return seqs, err
+		}
+
+		seqs = append(seqs, fs)
+	}
+}
+
+func TestReader(t *testing.T) {
+
+	twoSeqs := []FastaSeq{
+		{ID: "seq1", Desc: "first one", Seq: "ACDEFGHIK", Num: 1},
+		{ID: "seq2", Seq: "LMNPQ", Num: 2},
+	}
+
+	tests := []struct {
+		name		string
+		text		string
+		expected	[]FastaSeq
+		err			string		// Part of the error expected ("" if none)
+	}{
+		{name: "plain", text: ">seq1 first one\nACDEF\nGHIK\n>seq2\nLMNPQ\n", expected: twoSeqs},
+		{name: "CRLF", text: ">seq1 first one\r\nACDEF\r\nGHIK\r\n>seq2\r\nLMNPQ\r\n", expected: twoSeqs},
+		{name: "blank lines", text: "\n>seq1 first one\n\nACDEF\n  \nGHIK\n\n>seq2\nLMNPQ\n\n\n", expected: twoSeqs},
+		{name: "comment lines", text: ";file comment\n>seq1 first one\nACDEF\n; inside\nGHIK\n>seq2\nLMNPQ\n",
+			expected: twoSeqs},
+		{name: "last line without new line", text: ">seq1 first one\nACDEF\nGHIK\n>seq2\nLMNPQ", expected: twoSeqs},
+		{name: "CRLF without new line at the end", text: ">seq1 first one\r\nACDEFGHIK\r\n>seq2\r\nLMNPQ",
+			expected: twoSeqs},
+		{name: "tab before the description", text: ">seq1\tfirst one\nACDEFGHIK\n>seq2\nLMNPQ\n", expected: twoSeqs},
+		{name: "numbers of the input file", text: ">seq1 " + NUMTAG + "7\nACDEF\n>seq2 " + NUMTAG + "3\nLMNPQ\n",
+			expected: []FastaSeq{{ID: "seq1", Seq: "ACDEF", Num: 7}, {ID: "seq2", Seq: "LMNPQ", Num: 3}}},
+		{name: "header without sequence", text: ">seq1\n>seq2\nLMNPQ\n",
+			expected: []FastaSeq{{ID: "seq1", Num: 1}, {ID: "seq2", Seq: "LMNPQ", Num: 2}}},
+		{name: "empty file", text: "", expected: []FastaSeq{}},
+		{name: "only comments and blank lines", text: "; nothing\n\n\r\n", expected: []FastaSeq{}},
+		{name: "sequence without header", text: "ACDEF\n>seq1\nGHIK\n", expected: []FastaSeq{},
+			err: "without header at line 1"},
+		{name: "header without ID", text: ">seq1\nACDEF\n> desc\nGHIK\n", expected: []FastaSeq{},
+			err: "without ID at line 3"},
+		{name: "wrong number", text: ">seq1 " + NUMTAG + "x\nACDEF\n", expected: []FastaSeq{},
+			err: "Wrong sequence number"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			seqs, err := readAll(tt.text)
+
+			if tt.err == "" && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("Error %v, expected one with %q", err, tt.err)
+			}
+
+			if !reflect.DeepEqual(seqs, tt.expected) {
+				t.Errorf("Read %+v, expected %+v", seqs, tt.expected)
+			}
+		})
+	}
+}
+
+// The sequences written with their numbers are read back with them
+func TestWriterWithNum(t *testing.T) {
+
+	seqs := []FastaSeq{{ID: "seq4", Seq: "ACDEF", Num: 4}, {ID: "seq9", Seq: "GHIK", Num: 9}}
+
+	var buff bytes.Buffer
+	wrt := NewWriter(&buff)
+	wrt.WithNum = true
+
+	for _, fs := range seqs {
+		if err := wrt.Write(fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := wrt.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readAll(buff.String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(read, seqs) {
+		t.Errorf("Read %+v, expected %+v", read, seqs)
+	}
+}
@@ -0,0 +1,280 @@
+package bio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	. "bitbucket.org/germelcar/campred/common"
+	"errors"
+	"fmt"
+)
+
+// Extensions of the fasta files taken from the directories given as input
+var FASTAEXTS = []string{".fasta", ".fa", ".faa", ".fas", ".fna", ".fsa", ".ffn"}
+
+// Input file of a run with several files (one per sample). Its sequences are the sequences "First"
+// to "Last" (one based, included) of all the input files read one after another
+type Sample struct {
+	Name		string
+	FileName	string
+	First		int
+	Last		int
+}
+
+func (s Sample) NumSeqs() int {
+	return s.Last - s.First + 1
+}
+
+func (s Sample) Has(numSeq int) bool {
+	return numSeq >= s.First && numSeq <= s.Last
+}
+
+// Sample of the sequence (nil if there is none)
+func SampleOf(samples []Sample, numSeq int) *Sample {
+
+	i := sort.Search(len(samples), func(i int) bool {
+		return samples[i].Last >= numSeq
+	})
+
+	// Samples without sequences (Last < First) are skipped by the search
+	for ; i < len(samples); i++ {
+		if samples[i].Has(numSeq) {
+			return &samples[i]
+		}
+
+		if samples[i].First > numSeq {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Remove the extensions of compression and fasta of the name
+func trimFastaExt(fileName string) string {
+
+	if c := CompressionByName(fileName); c != NOCOMPRESSION {
+		fileName = strings.TrimSuffix(fileName, "." + c)
+	}
+
+	for _, ext := range FASTAEXTS {
+		if strings.HasSuffix(strings.ToLower(fileName), ext) {
+			return fileName[ : len(fileName) - len(ext)]
+		}
+	}
+
+	return fileName
+}
+
+// Name of the sample of the file: its name without directory and extensions (e.g. "data/s1.fa.gz" is "s1")
+func SampleName(fileName string) string {
+
+	if fileName == STDIO {
+		return "stdin"
+	}
+
+	return trimFastaExt(filepath.Base(fileName))
+}
+
+// File of a sample next to the file given, keeping its extensions (e.g. "amps.fa.gz" is "amps.s1.fa.gz")
+func SampleFileName(fileName, sample string) string {
+
+	stem := trimFastaExt(fileName)
+	ext := fileName[len(stem):]
+
+	if ext == "" || ext == "." + CompressionByName(fileName) {
+		ext = ".fasta" + ext
+	}
+
+	return stem + "." + sample + ext
+}
+
+func isFastaFile(fileName string) bool {
+
+	name := strings.ToLower(fileName)
+
+	if c := CompressionByName(name); c != NOCOMPRESSION {
+		name = strings.TrimSuffix(name, "." + c)
+	}
+
+	for _, ext := range FASTAEXTS {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Input files given by the values of the input option: files, "-" (the standard input), globs
+// (e.g. "data/*.fa.gz") and directories (their fasta files, see FASTAEXTS). A file given twice is read once
+func ExpandInputs(values []string) ([]string, error) {
+
+	files := []string{}
+	seen := make(map[string]bool)
+
+	add := func(fileName string) {
+		if !seen[fileName] {
+			seen[fileName] = true
+			files = append(files, fileName)
+		}
+	}
+
+	for _, value := range values {
+
+		if value == STDIO {
+			add(value)
+			continue
+		}
+
+		info, err := os.Stat(value)
+
+		// A directory: its fasta files
+		if err == nil && info.IsDir() {
+
+			entries, err := os.ReadDir(value)
+
+			if err != nil {
+				return nil, err
+			}
+
+			numFiles := 0
+
+			for _, e := range entries {
+				if !e.IsDir() && isFastaFile(e.Name()) {
+					add(filepath.Join(value, e.Name()))
+					numFiles++
+				}
+			}
+
+			if numFiles == 0 {
+				return nil, errors.New(fmt.Sprintf("No fasta files in directory %s", value))
+			}
+
+			continue
+		}
+
+		if err == nil {
+			add(value)
+			continue
+		}
+
+		// Not a file, maybe a glob
+		matches, gerr := filepath.Glob(value)
+
+		if gerr != nil || len(matches) == 0 {
+			return nil, errors.New(fmt.Sprintf("%s: %s", "Unable to find file", value))
+		}
+
+		sort.Strings(matches)
+
+		for _, m := range matches {
+			add(m)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("input filename is empty")
+	}
+
+	return files, nil
+}
+
+// Write the sequences of all the input files, one after another, in a single file (compressed as its
+// extension says), so they can be read more than once (e.g. the standard input) and sent together.
+// Returns the samples of the files
+func CombineFiles(inFiles []string, outFile string, verbose bool) ([]Sample, error) {
+
+	fout, err := CreateFile(outFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	wrt := NewWriter(fout)
+	samples := []Sample{}
+	names := make(map[string]int)
+	totSeqs := 0
+
+	for _, inFile := range inFiles {
+
+		name := SampleName(inFile)
+		names[name]++
+
+		// Files with the same name in different directories
+		if names[name] > 1 {
+			name = fmt.Sprintf("%s_%d", name, names[name])
+		}
+
+		sample := Sample{Name: name, FileName: inFile, First: totSeqs + 1}
+		fin, err := OpenFile(inFile)
+
+		if err != nil {
+			fout.Close()
+			return nil, err
+		}
+
+		rdr := NewReader(fin)
+
+		for {
+
+			fs, err := rdr.Next()
+
+			if err == io.EOF {
+				break
+			}
+
+			if err == nil {
+				err = wrt.Write(fs)
+			}
+
+			if err != nil {
+				fin.Close()
+				fout.Close()
+				return nil, errors.New(fmt.Sprintf("Error while combining %s in %s: %s", inFile, outFile, err))
+			}
+
+			totSeqs++
+		}
+
+		fin.Close()
+		sample.Last = totSeqs
+		samples = append(samples, sample)
+
+		if verbose {
+			InfoLog.Printf("Sample %s: %d sequences (%s)", sample.Name, sample.NumSeqs(), inFile)
+		}
+	}
+
+	err = wrt.Flush()
+
+	if err != nil {
+		fout.Close()
+		return nil, err
+	}
+
+	return samples, fout.Close()
+}
+
+// Samples of the file written from the sequences of the samples given, "numOut[i]" sequences for the
+// (i + 1)-th sequence (e.g. its peptides or its windows)
+func RemapSamples(samples []Sample, numOut []int) []Sample {
+
+	remapped := []Sample{}
+	tot := 0
+
+	for _, s := range samples {
+
+		first := tot + 1
+
+		for numSeq := s.First; numSeq <= s.Last && numSeq <= len(numOut); numSeq++ {
+			tot += numOut[numSeq - 1]
+		}
+
+		remapped = append(remapped, Sample{Name: s.Name, FileName: s.FileName, First: first, Last: tot})
+	}
+
+	return remapped
+}
@@ -0,0 +1,287 @@
+package bio
+
+import (
+	"sort"
+	"strings"
+	"errors"
+	"fmt"
+)
+
+// How the nucleotide sequences are translated into peptides
+const (
+	TRANSLATENONE	= "none"	// The input has protein sequences
+	TRANSLATEFRAMES	= "frames"	// Six-frame translation, the peptides are the stretches between stop codons
+	TRANSLATEORF	= "orf"		// Open reading frames: from a start codon to a stop codon (or the end of the sequence)
+
+	DEFAULTGENETICCODE	= 1
+	DEFAULTORFMINLEN	= 10	// Amino acids
+)
+
+// Genetic code (translation table) as given by the NCBI: the amino acid and whether it is a start
+// codon for the 64 codons in the order TTT, TTC, TTA, TTG, TCT... (bases in the order T, C, A, G)
+type GeneticCode struct {
+	ID		int
+	Name	string
+	aas		string
+	starts	string
+}
+
+var GENETICCODES = map[int]GeneticCode{
+	1: {1, "Standard",
+		"FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"---M---------------M---------------M----------------------------"},
+	2: {2, "Vertebrate Mitochondrial",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSS**VVVVAAAADDEEGGGG",
+		"--------------------------------MMMM---------------M------------"},
+	3: {3, "Yeast Mitochondrial",
+		"FFLLSSSSYY**CCWWTTTTPPPPHHQQRRRRIIMMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"----------------------------------MM---------------M------------"},
+	4: {4, "Mold, Protozoan, Coelenterate Mitochondrial and Mycoplasma/Spiroplasma",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"--MM---------------M------------MMMM---------------M------------"},
+	5: {5, "Invertebrate Mitochondrial",
+		"FFLLSSSSYY**CCWWLLLLPPPPHHQQRRRRIIMMTTTTNNKKSSSSVVVVAAAADDEEGGGG",
+		"---M----------------------------MMMM---------------M------------"},
+	6: {6, "Ciliate, Dasycladacean and Hexamita Nuclear",
+		"FFLLSSSSYYQQCC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"-----------------------------------M----------------------------"},
+	11: {11, "Bacterial, Archaeal and Plant Plastid",
+		"FFLLSSSSYY**CC*WLLLLPPPPHHQQRRRRIIIMTTTTNNKKSSRRVVVVAAAADDEEGGGG",
+		"---M---------------M------------MMMM---------------M------------"},
+}
+
+// IDs of the genetic codes available, sorted
+func GeneticCodeIDs() []int {
+
+	ids := []int{}
+
+	for id := range GENETICCODES {
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	return ids
+}
+
+// Position of the codon in the tables of the genetic codes, -1 if it has other bases than A, C, G and T
+func codonIndex(codon string) int {
+
+	idx := 0
+
+	for i := 0; i < 3; i++ {
+
+		var b int
+
+		switch codon[i] {
+
+		case 'T':
+			b = 0
+
+		case 'C':
+			b = 1
+
+		case 'A':
+			b = 2
+
+		case 'G':
+			b = 3
+
+		default:
+			return -1
+
+		}
+
+		idx = idx * 4 + b
+	}
+
+	return idx
+}
+
+// Amino acid of the codon ("*" for the stop codons and "X" if it has ambiguous bases)
+func (gc GeneticCode) Translate(codon string) byte {
+
+	idx := codonIndex(codon)
+
+	if idx < 0 {
+		return 'X'
+	}
+
+	return gc.aas[idx]
+}
+
+func (gc GeneticCode) IsStart(codon string) bool {
+
+	idx := codonIndex(codon)
+
+	return idx >= 0 && gc.starts[idx] == 'M'
+}
+
+var complement = map[byte]byte{'A': 'T', 'T': 'A', 'C': 'G', 'G': 'C'}
+
+func reverseComplement(seq string) string {
+
+	rc := make([]byte, len(seq))
+
+	for i := 0; i < len(seq); i++ {
+
+		c, ok := complement[seq[i]]
+
+		if !ok {
+			c = 'N'
+		}
+
+		rc[len(seq) - 1 - i] = c
+	}
+
+	return string(rc)
+}
+
+// Translation of nucleotide sequences into peptides. The peptides shorter than MinLen or longer
+// than MaxLen (amino acids) are discarded. A length of 0 means no limit
+type Translation struct {
+	Mode	string
+	Code	int
+	MinLen	int
+	MaxLen	int
+}
+
+func (t Translation) String() string {
+
+	if t.Mode == TRANSLATENONE || t.Mode == "" {
+		return TRANSLATENONE
+	}
+
+	if t.MaxLen == 0 {
+		return fmt.Sprintf("%s (genetic code %d, peptides of %d or more amino acids)", t.Mode, t.Code, t.MinLen)
+	}
+
+	return fmt.Sprintf("%s (genetic code %d, peptides of %d to %d amino acids)", t.Mode, t.Code, t.MinLen, t.MaxLen)
+}
+
+// Check the mode and the genetic code of the translation
+func (t Translation) Check() error {
+
+	if t.Mode != TRANSLATENONE && t.Mode != TRANSLATEFRAMES && t.Mode != TRANSLATEORF {
+		return errors.New(fmt.Sprintf("Unrecognized translation: %s (valid: %s, %s, %s)", t.Mode,
+			TRANSLATENONE, TRANSLATEFRAMES, TRANSLATEORF))
+	}
+
+	if _, ok := GENETICCODES[t.Code]; !ok {
+		return errors.New(fmt.Sprintf("Unrecognized genetic code: %d (valid: %v)", t.Code, GeneticCodeIDs()))
+	}
+
+	if t.MinLen < 0 || t.MaxLen < 0 || (t.MaxLen > 0 && t.MaxLen < t.MinLen) {
+		return errors.New(fmt.Sprintf("Invalid range of lengths of the peptides: %d to %d", t.MinLen, t.MaxLen))
+	}
+
+	return nil
+}
+
+func (t Translation) validLen(numAAs int) bool {
+	return numAAs > 0 && numAAs >= t.MinLen && (t.MaxLen == 0 || numAAs <= t.MaxLen)
+}
+
+// Peptide of the amino acids "first" to "last" (zero based, included) of a frame of the sequence. Its ID
+// points back to the sequence, the frame (f1 to f3 forward, r1 to r3 reverse) and the coordinates
+// (one based, in the forward strand) of its codons
+func peptide(fs FastaSeq, strand byte, frame, first, last int, aas string) FastaSeq {
+
+	seqLen := len(fs.Seq)
+	start := frame + first * 3 + 1
+	end := frame + (last + 1) * 3
+
+	sign := "+"
+
+	if strand == 'r' {
+		start, end = seqLen - end + 1, seqLen - start + 1
+		sign = "-"
+	}
+
+	return FastaSeq{
+		ID: fmt.Sprintf("%s_%c%d_%d-%d", fs.ID, strand, frame + 1, start, end),
+		Desc: fmt.Sprintf("contig=%s frame=%s%d start=%d end=%d", fs.ID, sign, frame + 1, start, end),
+		Seq: aas,
+	}
+}
+
+// Peptides of one frame of the (forward or reverse complemented) sequence
+func (t Translation) framePeptides(fs FastaSeq, seq string, strand byte, frame int) []FastaSeq {
+
+	gc := GENETICCODES[t.Code]
+	peps := []FastaSeq{}
+	numCodons := (len(seq) - frame) / 3
+
+	// First amino acid of the current peptide (-1 if there is none)
+	first := -1
+	var aas strings.Builder
+
+	emit := func(last int) {
+
+		if first >= 0 && t.validLen(aas.Len()) {
+			peps = append(peps, peptide(fs, strand, frame, first, last, aas.String()))
+		}
+
+		first = -1
+		aas.Reset()
+	}
+
+	for i := 0; i < numCodons; i++ {
+
+		codon := seq[frame + i * 3 : frame + i * 3 + 3]
+		aa := gc.Translate(codon)
+
+		if aa == '*' {
+			emit(i - 1)
+			continue
+		}
+
+		if first < 0 {
+
+			// An ORF starts by a start codon, which is always translated as methionine
+			if t.Mode == TRANSLATEORF {
+
+				if !gc.IsStart(codon) {
+					continue
+				}
+
+				aa = 'M'
+			}
+
+			first = i
+		}
+
+		aas.WriteByte(aa)
+	}
+
+	// The last peptide could have no stop codon
+	emit(numCodons - 1)
+
+	return peps
+}
+
+// Peptides of the six frames of the nucleotide sequence
+func (t Translation) Peptides(fs FastaSeq) []FastaSeq {
+
+	// RNA sequences are translated as DNA
+	seq := strings.Replace(strings.ToUpper(fs.Seq), "U", "T", -1)
+	fs.Seq = seq
+	rc := reverseComplement(seq)
+	peps := []FastaSeq{}
+
+	for frame := 0; frame < 3; frame++ {
+		peps = append(peps, t.framePeptides(fs, seq, 'f', frame)...)
+	}
+
+	for frame := 0; frame < 3; frame++ {
+		peps = append(peps, t.framePeptides(fs, rc, 'r', frame)...)
+	}
+
+	return peps
+}
+
+// Translate the nucleotide sequences of the input file and write their peptides (see Peptides).
+// Returns the number of peptides of each sequence and the number of peptides written
+func TranslateFasta(inFile, outFile string, t Translation, verbose bool) ([]int, int, error) {
+	return transformFasta(inFile, outFile, "peptides", verbose, t.Peptides)
+}
@@ -0,0 +1,124 @@
+package bio
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Amino acids of the codons of the peptide, taken from the sequence by the coordinates and the strand
+// of its ID (e.g. "s_r3_6-14")
+func codonsOf(t *testing.T, seq string, pep FastaSeq, code int) string {
+
+	var strand byte
+	var frame, start, end int
+	idx := strings.LastIndex(pep.ID[ : strings.LastIndex(pep.ID, "_")], "_")
+	_, err := fmt.Sscanf(pep.ID[idx + 1 : ], "%c%d_%d-%d", &strand, &frame, &start, &end)
+
+	if err != nil {
+		t.Fatalf("Invalid peptide ID %s: %s", pep.ID, err)
+	}
+
+	nts := strings.Replace(strings.ToUpper(seq), "U", "T", -1)[start - 1 : end]
+
+	if strand == 'r' {
+		nts = reverseComplement(nts)
+	}
+
+	var aas strings.Builder
+
+	for i := 0; i + 3 <= len(nts); i += 3 {
+		aas.WriteByte(GENETICCODES[code].Translate(nts[i : i + 3]))
+	}
+
+	return aas.String()
+}
+
+func TestTranslation(t *testing.T) {
+
+	orf := Translation{Mode: TRANSLATEORF, Code: DEFAULTGENETICCODE, MinLen: 1}
+	frames := Translation{Mode: TRANSLATEFRAMES, Code: DEFAULTGENETICCODE, MinLen: 1}
+	withLens := func(tr Translation, minLen, maxLen int) Translation {
+		tr.MinLen, tr.MaxLen = minLen, maxLen
+		return tr
+	}
+
+	tests := []struct {
+		name		string
+		seq			string
+		trans		Translation
+		expected	[]string	// ID and sequence of the peptides
+	}{
+		{name: "ORF on the forward strand", seq: "CCATGAAACGTTAAGG", trans: orf,
+			expected: []string{"s_f3_3-11 MKR", "s_r1_2-4 M"}},
+		{name: "ORF on the reverse strand", seq: "GGTTAACGTTTCATCC", trans: orf,
+			expected: []string{"s_r3_6-14 MKR"}},
+		{name: "ORF without stop codon", seq: "ATGAAACGT", trans: orf, expected: []string{"s_f1_1-9 MKR"}},
+		{name: "ORFs of all the frames", seq: "ATGTGATGAAATAGCCATGGG", trans: orf,
+			expected: []string{"s_f1_1-3 M", "s_f2_17-19 M", "s_f3_6-11 MK", "s_r1_1-18 MAISSH"}},
+		{name: "ORF minimum length", seq: "ATGTGATGAAATAGCCATGGG", trans: withLens(orf, 2, 0),
+			expected: []string{"s_f3_6-11 MK", "s_r1_1-18 MAISSH"}},
+		{name: "ORF maximum length", seq: "ATGTGATGAAATAGCCATGGG", trans: withLens(orf, 1, 2),
+			expected: []string{"s_f1_1-3 M", "s_f2_17-19 M", "s_f3_6-11 MK"}},
+		{name: "ORF range of lengths", seq: "ATGTGATGAAATAGCCATGGG", trans: withLens(orf, 2, 2),
+			expected: []string{"s_f3_6-11 MK"}},
+		{name: "RNA in lowercase", seq: "auggcguaa", trans: orf, expected: []string{"s_f1_1-6 MA"}},
+		{name: "frames between stop codons", seq: "ATGTGATGAAATAGCCATGGG", trans: withLens(frames, 5, 0),
+			expected: []string{"s_f2_2-19 CDEIAM", "s_r1_1-21 PMAISSH", "s_r2_3-20 PWLFHH", "s_r3_2-19 HGYFIT"}},
+		{name: "frames on the reverse strand", seq: "GGTTAACGTTTCATCC", trans: withLens(frames, 3, 3),
+			expected: []string{"s_f1_7-15 RFI", "s_r1_2-10 NVN", "s_r3_6-14 MKR"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := []string{}
+
+			for _, pep := range tt.trans.Peptides(FastaSeq{ID: "s", Seq: tt.seq}) {
+
+				got = append(got, pep.ID + " " + pep.Seq)
+
+				// The coordinates are the ones of the codons of the peptide (an ORF always starts by methionine)
+				aas := codonsOf(t, tt.seq, pep, tt.trans.Code)
+
+				if tt.trans.Mode == TRANSLATEORF && len(aas) > 0 {
+					aas = "M" + aas[1 : ]
+				}
+
+				if aas != pep.Seq {
+					t.Errorf("%s: %s, but its codons are %s", pep.ID, pep.Seq, aas)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Peptides %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTranslationCheck(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		trans		Translation
+		valid		bool
+	}{
+		{name: "ORF", trans: Translation{Mode: TRANSLATEORF, Code: 1, MinLen: 10, MaxLen: 50}, valid: true},
+		{name: "unknown mode", trans: Translation{Mode: "three", Code: 1}},
+		{name: "unknown genetic code", trans: Translation{Mode: TRANSLATEFRAMES, Code: 99}},
+		{name: "maximum below minimum", trans: Translation{Mode: TRANSLATEORF, Code: 1, MinLen: 10, MaxLen: 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := tt.trans.Check()
+
+			if (err == nil) != tt.valid {
+				t.Errorf("Error %v, expected valid: %v", err, tt.valid)
+			}
+		})
+	}
+}
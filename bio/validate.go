@@ -0,0 +1,469 @@
+package bio
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"errors"
+	"fmt"
+)
+
+// Issues found in the sequences. CAMP only accepts the 20 standard amino acids (in uppercase)
+const (
+	ISSUEEMPTY		= "empty"		// No residues (or none left after stripping the others)
+	ISSUELOWERCASE	= "lowercase"
+	ISSUESTOP		= "stop"		// Stop codons ("*")
+	ISSUEGAP		= "gap"			// Gaps of alignments ("-" or ".")
+	ISSUEAMBIGUOUS	= "ambiguous"	// Ambiguity codes (X, B, Z, J) and non standard amino acids (U, O)
+	ISSUENUCLEOTIDE	= "nucleotide"	// It looks like a nucleotide sequence (see NUCLEOTIDEMINFRAC)
+	ISSUEINVALID	= "invalid"		// Any other character (digits, symbols...)
+	ISSUESHORT		= "short"		// Shorter than the minimum length (see Validation)
+	ISSUELONG		= "long"		// Longer than the maximum length
+)
+
+// What is done with the sequences with an issue
+const (
+	POLICYSTRIP		= "strip"	// Remove the offending residues (or change them to uppercase) and send the rest
+	POLICYEXCLUDE	= "exclude"	// Do not send the sequence, only log it in the rejects file
+	POLICYREJECT	= "reject"	// Stop the run
+
+	// The sequences out of the range of lengths are never sent, but they are not an error of the input
+	NOTEVALUATED	= "not-evaluated"
+)
+
+const (
+	AMINOACIDS			= "ACDEFGHIKLMNPQRSTVWY"
+	AMBIGUOUS			= "XBZJUO"
+	GAPS				= "-."
+	STOP				= "*"
+	NUCLEOTIDES			= "ACGTUN"
+	NUCLEOTIDEMINLEN	= 20	// Shorter sequences are never taken as nucleotides (e.g. "GAGAGA" is a peptide)
+	NUCLEOTIDEMINFRAC	= 0.9	// Minimum fraction of A, C, G, T, U and N for a nucleotide sequence
+)
+
+// Order in which the issues are checked and reported
+var ISSUES = []string{ISSUEEMPTY, ISSUELOWERCASE, ISSUESTOP, ISSUEGAP, ISSUEAMBIGUOUS, ISSUENUCLEOTIDE, ISSUEINVALID}
+
+// Policy per issue
+type Policies map[string]string
+
+func DefaultPolicies() Policies {
+	return Policies{
+		ISSUEEMPTY: POLICYEXCLUDE,
+		ISSUELOWERCASE: POLICYSTRIP,
+		ISSUESTOP: POLICYSTRIP,
+		ISSUEGAP: POLICYSTRIP,
+		ISSUEAMBIGUOUS: POLICYEXCLUDE,
+		ISSUENUCLEOTIDE: POLICYEXCLUDE,
+		ISSUEINVALID: POLICYEXCLUDE,
+	}
+}
+
+// Parse the policies with the form "issue=policy,issue=policy" (e.g. "ambiguous=strip,nucleotide=reject").
+// The issues not given keep their default policy
+func ParsePolicies(value string) (Policies, error) {
+
+	policies := DefaultPolicies()
+
+	if value == "" {
+		return policies, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+
+		fields := strings.Split(pair, "=")
+
+		if len(fields) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid sanitize policy: %s", pair))
+		}
+
+		issue := strings.ToLower(strings.TrimSpace(fields[0]))
+		policy := strings.ToLower(strings.TrimSpace(fields[1]))
+
+		if _, ok := policies[issue]; !ok {
+			return nil, errors.New(fmt.Sprintf("Unrecognized sequence issue: %s (valid: %s)",
+				issue, strings.Join(ISSUES, ", ")))
+		}
+
+		if policy != POLICYSTRIP && policy != POLICYEXCLUDE && policy != POLICYREJECT {
+			return nil, errors.New(fmt.Sprintf("Unrecognized policy for %s: %s (valid: strip, exclude, reject)",
+				issue, policy))
+		}
+
+		// There is nothing to strip from an empty or a nucleotide sequence
+		if policy == POLICYSTRIP && (issue == ISSUEEMPTY || issue == ISSUENUCLEOTIDE) {
+			return nil, errors.New(fmt.Sprintf("The %s sequences can not be stripped, only excluded or rejected", issue))
+		}
+
+		policies[issue] = policy
+	}
+
+	return policies, nil
+}
+
+func (p Policies) String() string {
+
+	pairs := []string{}
+
+	for _, issue := range ISSUES {
+		pairs = append(pairs, issue + "=" + p[issue])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Sequence with issues and what was done with it
+type Reject struct {
+	Num		int
+	ID		string
+	Issues	[]string
+	Action	string
+}
+
+// Severity of the policies, the most severe policy of the issues of a sequence is applied
+func severity(policy string) int {
+
+	switch policy {
+
+	case POLICYSTRIP:
+		return 1
+
+	case POLICYEXCLUDE:
+		return 2
+
+	case POLICYREJECT:
+		return 3
+
+	}
+
+	return 0
+}
+
+// Issues of the sequence, in the order of ISSUES
+func seqIssues(seq string) []string {
+
+	if seq == "" {
+		return []string{ISSUEEMPTY}
+	}
+
+	found := make(map[string]bool)
+	numNucl := 0
+	upper := strings.ToUpper(seq)
+
+	if upper != seq {
+		found[ISSUELOWERCASE] = true
+	}
+
+	for _, r := range upper {
+
+		if strings.ContainsRune(NUCLEOTIDES, r) {
+			numNucl++
+		}
+
+		switch {
+
+		case strings.ContainsRune(AMINOACIDS, r):
+
+		case strings.ContainsRune(STOP, r):
+			found[ISSUESTOP] = true
+
+		case strings.ContainsRune(GAPS, r):
+			found[ISSUEGAP] = true
+
+		case strings.ContainsRune(AMBIGUOUS, r):
+			found[ISSUEAMBIGUOUS] = true
+
+		default:
+			found[ISSUEINVALID] = true
+
+		}
+	}
+
+	if len(seq) >= NUCLEOTIDEMINLEN && float64(numNucl) >= NUCLEOTIDEMINFRAC * float64(len(seq)) {
+		found[ISSUENUCLEOTIDE] = true
+	}
+
+	issues := []string{}
+
+	for _, issue := range ISSUES {
+		if found[issue] {
+			issues = append(issues, issue)
+		}
+	}
+
+	return issues
+}
+
+// Only the standard amino acids (in uppercase) of the sequence
+func stripSeq(seq string) string {
+
+	var b strings.Builder
+
+	for _, r := range strings.ToUpper(seq) {
+		if strings.ContainsRune(AMINOACIDS, r) {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// Check the sequence. If it has issues, the returned sequence is the one to send (stripped if needed)
+// and the reject says what was done with it (nil if the sequence has no issues)
+func (p Policies) Check(fs FastaSeq) (FastaSeq, *Reject) {
+
+	issues := seqIssues(fs.Seq)
+
+	if len(issues) == 0 {
+		return fs, nil
+	}
+
+	action := POLICYSTRIP
+
+	for _, issue := range issues {
+		if severity(p[issue]) > severity(action) {
+			action = p[issue]
+		}
+	}
+
+	if action == POLICYSTRIP {
+
+		fs.Seq = stripSeq(fs.Seq)
+
+		// Nothing left after stripping
+		if fs.Seq == "" {
+			issues = append(issues, ISSUEEMPTY)
+			action = p[ISSUEEMPTY]
+		}
+	}
+
+	return fs, &Reject{Num: fs.Num, ID: fs.ID, Issues: issues, Action: action}
+}
+
+// What is checked of the sequences: the policy per issue and the range of lengths (after stripping
+// the sequence). A length of 0 means no limit
+type Validation struct {
+	Policies	Policies
+	MinLen		int
+	MaxLen		int
+}
+
+func (vd Validation) String() string {
+
+	lengths := "any"
+
+	switch {
+
+	case vd.MinLen > 0 && vd.MaxLen > 0:
+		lengths = fmt.Sprintf("%d to %d", vd.MinLen, vd.MaxLen)
+
+	case vd.MinLen > 0:
+		lengths = fmt.Sprintf("%d or more", vd.MinLen)
+
+	case vd.MaxLen > 0:
+		lengths = fmt.Sprintf("up to %d", vd.MaxLen)
+
+	}
+
+	return fmt.Sprintf("%s (lengths: %s)", vd.Policies, lengths)
+}
+
+// Whether the length of the sequence is out of the range (its issue) or not (empty)
+func (vd Validation) checkLen(fs FastaSeq) string {
+
+	if vd.MinLen > 0 && fs.Len() < vd.MinLen {
+		return ISSUESHORT
+	}
+
+	if vd.MaxLen > 0 && fs.Len() > vd.MaxLen {
+		return ISSUELONG
+	}
+
+	return ""
+}
+
+// Error of a sequence whose issues have the reject policy
+type RejectError struct {
+	Reject
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("Sequence %d (%s) rejected: %s", e.Num, e.ID, strings.Join(e.Issues, ", "))
+}
+
+func IsRejected(err error) bool {
+
+	var re *RejectError
+
+	return errors.As(err, &re)
+}
+
+// Validation of the sequences read (see Reader), keeping the sequences with issues
+type Validator struct {
+	Validation
+
+	rejects		[]Reject
+	mu			sync.Mutex
+}
+
+func NewValidator(vd Validation) *Validator {
+	return &Validator{Validation: vd}
+}
+
+// Check the sequence and record its issues (if any). The sequence must not be sent if "ok" is false
+func (v *Validator) Check(fs FastaSeq) (FastaSeq, bool, error) {
+
+	fs, rej := v.Policies.Check(fs)
+
+	// The length is checked once the sequence can be sent (stripped if needed)
+	if rej == nil || rej.Action == POLICYSTRIP {
+
+		if issue := v.checkLen(fs); issue != "" {
+
+			if rej == nil {
+				rej = &Reject{Num: fs.Num, ID: fs.ID}
+			}
+
+			rej.Issues = append(rej.Issues, issue)
+			rej.Action = NOTEVALUATED
+		}
+	}
+
+	if rej == nil {
+		return fs, true, nil
+	}
+
+	v.mu.Lock()
+	v.rejects = append(v.rejects, *rej)
+	v.mu.Unlock()
+
+	switch rej.Action {
+
+	case POLICYREJECT:
+		return fs, false, &RejectError{*rej}
+
+	case POLICYEXCLUDE, NOTEVALUATED:
+		return fs, false, nil
+
+	}
+
+	return fs, true, nil
+}
+
+// Sequences with issues found so far, in the order of the input file
+func (v *Validator) Rejects() []Reject {
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	rejects := append([]Reject{}, v.rejects...)
+
+	sort.Slice(rejects, func(i, j int) bool {
+		return rejects[i].Num < rejects[j].Num
+	})
+
+	return rejects
+}
+
+// Number of sequences with issues by the action applied
+func (v *Validator) Count(action string) int {
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	tot := 0
+
+	for _, rej := range v.rejects {
+		if rej.Action == action {
+			tot++
+		}
+	}
+
+	return tot
+}
+
+// Write the sequences with issues (tab separated): their number in the input file, their ID,
+// their issues and what was done with them
+func (v *Validator) WriteRejects(outFile string) error {
+
+	fout, err := os.Create(outFile)
+
+	if err != nil {
+		return err
+	}
+
+	wrt := bufio.NewWriter(fout)
+
+	_, err = wrt.WriteString("Number\tID\tIssues\tAction\n")
+
+	if err != nil {
+		fout.Close()
+		return err
+	}
+
+	for _, rej := range v.Rejects() {
+
+		_, err = wrt.WriteString(fmt.Sprintf("%d\t%s\t%s\t%s\n", rej.Num, rej.ID, strings.Join(rej.Issues, ","),
+			rej.Action))
+
+		if err != nil {
+			fout.Close()
+			return errors.New(fmt.Sprintf("Error while writting rejects file %s: %s", outFile, err))
+		}
+	}
+
+	err = wrt.Flush()
+
+	if err != nil {
+		fout.Close()
+		return err
+	}
+
+	return fout.Close()
+}
+
+// Read the sequences with issues written by WriteRejects
+func ReadRejects(inFile string) ([]Reject, error) {
+
+	fin, err := os.Open(inFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fin.Close()
+	rejects := []Reject{}
+	scanner := bufio.NewScanner(fin)
+	numLine := 0
+
+	for scanner.Scan() {
+
+		numLine++
+
+		// Header
+		if numLine == 1 {
+			continue
+		}
+
+		fields := strings.Split(scanner.Text(), "\t")
+
+		if len(fields) != 4 {
+			return nil, errors.New(fmt.Sprintf("Invalid line %d of rejects file %s", numLine, inFile))
+		}
+
+		num, err := strconv.Atoi(fields[0])
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid number of sequence in line %d of rejects file %s: %s",
+				numLine, inFile, fields[0]))
+		}
+
+		rejects = append(rejects, Reject{Num: num, ID: fields[1], Issues: strings.Split(fields[2], ","),
+			Action: fields[3]})
+	}
+
+	return rejects, scanner.Err()
+}
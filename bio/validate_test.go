@@ -0,0 +1,193 @@
+package bio
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidator(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		policies	string		// See ParsePolicies ("" for the default ones)
+		minLen		int
+		maxLen		int
+		seq			string
+		ok			bool		// The sequence is sent
+		sent		string		// What is sent (stripped if needed)
+		issues		[]string	// nil if the sequence has no issues
+		action		string
+		rejected	bool		// The run stops
+	}{
+		{name: "no issues", seq: "ACDEF", ok: true, sent: "ACDEF"},
+		{name: "lowercase stripped", seq: "acdef", ok: true, sent: "ACDEF", issues: []string{ISSUELOWERCASE},
+			action: POLICYSTRIP},
+		{name: "stop and gaps stripped", seq: "AC-D.E*", ok: true, sent: "ACDE",
+			issues: []string{ISSUESTOP, ISSUEGAP}, action: POLICYSTRIP},
+		{name: "ambiguous excluded", seq: "ACXDE", issues: []string{ISSUEAMBIGUOUS}, action: POLICYEXCLUDE},
+		{name: "ambiguous stripped", policies: "ambiguous=strip", seq: "ACXDE", ok: true, sent: "ACDE",
+			issues: []string{ISSUEAMBIGUOUS}, action: POLICYSTRIP},
+		{name: "invalid rejected", policies: "invalid=reject", seq: "AC1DE", issues: []string{ISSUEINVALID},
+			action: POLICYREJECT, rejected: true},
+		{name: "most severe policy", seq: "ac1de", issues: []string{ISSUELOWERCASE, ISSUEINVALID},
+			action: POLICYEXCLUDE},
+		{name: "most severe policy rejects", policies: "lowercase=reject", seq: "ac-de",
+			issues: []string{ISSUELOWERCASE, ISSUEGAP}, action: POLICYREJECT, rejected: true},
+		{name: "nucleotide excluded", seq: "ATGGCGTTAGCCGATAACGTTGCA", issues: []string{ISSUENUCLEOTIDE},
+			action: POLICYEXCLUDE},
+		{name: "short nucleotide is a peptide", seq: "GAGAGA", ok: true, sent: "GAGAGA"},
+		{name: "empty excluded", seq: "", issues: []string{ISSUEEMPTY}, action: POLICYEXCLUDE},
+		{name: "nothing left after stripping", seq: "--..", issues: []string{ISSUEGAP, ISSUEEMPTY},
+			action: POLICYEXCLUDE},
+		{name: "nothing left after stripping rejected", policies: "empty=reject", seq: "**",
+			issues: []string{ISSUESTOP, ISSUEEMPTY}, action: POLICYREJECT, rejected: true},
+		{name: "short not evaluated", minLen: 5, seq: "ACD", issues: []string{ISSUESHORT}, action: NOTEVALUATED},
+		{name: "long not evaluated", maxLen: 3, seq: "ACDE", issues: []string{ISSUELONG}, action: NOTEVALUATED},
+		{name: "length after stripping", minLen: 5, seq: "AC-DE", issues: []string{ISSUEGAP, ISSUESHORT},
+			action: NOTEVALUATED},
+		{name: "length in range", minLen: 3, maxLen: 5, seq: "ACDEF", ok: true, sent: "ACDEF"},
+		{name: "length of excluded not checked", minLen: 10, seq: "ACXDE", issues: []string{ISSUEAMBIGUOUS},
+			action: POLICYEXCLUDE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			policies, err := ParsePolicies(tt.policies)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := NewValidator(Validation{Policies: policies, MinLen: tt.minLen, MaxLen: tt.maxLen})
+			fs, ok, err := v.Check(FastaSeq{ID: "seq1", Seq: tt.seq, Num: 1})
+
+			if IsRejected(err) != tt.rejected {
+				t.Fatalf("Error %v, expected a reject error: %v", err, tt.rejected)
+			}
+
+			if !tt.rejected && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if ok != tt.ok {
+				t.Errorf("Sent: %v, expected %v", ok, tt.ok)
+			}
+
+			if ok && fs.Seq != tt.sent {
+				t.Errorf("Sent %q, expected %q", fs.Seq, tt.sent)
+			}
+
+			rejects := v.Rejects()
+
+			if tt.issues == nil {
+
+				if len(rejects) > 0 {
+					t.Errorf("Unexpected rejects: %+v", rejects)
+				}
+
+				return
+			}
+
+			if len(rejects) != 1 {
+				t.Fatalf("%d rejects, expected 1", len(rejects))
+			}
+
+			if !reflect.DeepEqual(rejects[0].Issues, tt.issues) || rejects[0].Action != tt.action {
+				t.Errorf("Issues %v (%s), expected %v (%s)", rejects[0].Issues, rejects[0].Action, tt.issues,
+					tt.action)
+			}
+		})
+	}
+}
+
+func TestParsePolicies(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		value		string
+		err			string		// Part of the error expected ("" if none)
+	}{
+		{name: "defaults", value: ""},
+		{name: "several", value: "ambiguous=strip, nucleotide=reject,GAP=exclude"},
+		{name: "no policy", value: "gap", err: "Invalid sanitize policy"},
+		{name: "unknown issue", value: "digits=strip", err: "Unrecognized sequence issue"},
+		{name: "unknown policy", value: "gap=drop", err: "Unrecognized policy"},
+		{name: "empty stripped", value: "empty=strip", err: "can not be stripped"},
+		{name: "nucleotide stripped", value: "nucleotide=strip", err: "can not be stripped"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, err := ParsePolicies(tt.value)
+
+			if tt.err == "" && err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("Error %v, expected one with %q", err, tt.err)
+			}
+		})
+	}
+}
+
+// The rejects file has the sequences with issues in the order of the input file, and is read back
+// as written
+func TestRejectsFile(t *testing.T) {
+
+	policies, err := ParsePolicies("ambiguous=strip")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := NewValidator(Validation{Policies: policies, MinLen: 3})
+
+	// Checked out of order, as the splitting threads do
+	for _, fs := range []FastaSeq{
+		{ID: "seq5", Seq: "ACD*", Num: 5},
+		{ID: "seq2", Seq: "AC", Num: 2},
+		{ID: "seq3", Seq: "ACDEF", Num: 3},
+		{ID: "seq1", Seq: "acxde", Num: 1},
+		{ID: "seq4", Seq: "", Num: 4},
+	} {
+		v.Check(fs)
+	}
+
+	expected := []Reject{
+		{Num: 1, ID: "seq1", Issues: []string{ISSUELOWERCASE, ISSUEAMBIGUOUS}, Action: POLICYSTRIP},
+		{Num: 2, ID: "seq2", Issues: []string{ISSUESHORT}, Action: NOTEVALUATED},
+		{Num: 4, ID: "seq4", Issues: []string{ISSUEEMPTY}, Action: POLICYEXCLUDE},
+		{Num: 5, ID: "seq5", Issues: []string{ISSUESTOP}, Action: POLICYSTRIP},
+	}
+
+	if !reflect.DeepEqual(v.Rejects(), expected) {
+		t.Fatalf("Rejects %+v, expected %+v", v.Rejects(), expected)
+	}
+
+	if v.Count(POLICYSTRIP) != 2 || v.Count(NOTEVALUATED) != 1 || v.Count(POLICYEXCLUDE) != 1 {
+		t.Errorf("Counts by action: %d stripped, %d not evaluated, %d excluded", v.Count(POLICYSTRIP),
+			v.Count(NOTEVALUATED), v.Count(POLICYEXCLUDE))
+	}
+
+	fileName := filepath.Join(t.TempDir(), "in.rejects.tsv")
+	err = v.WriteRejects(fileName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadRejects(fileName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(read, expected) {
+		t.Errorf("Read %+v, expected %+v", read, expected)
+	}
+}
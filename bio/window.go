@@ -0,0 +1,141 @@
+package bio
+
+import (
+	"errors"
+	"fmt"
+)
+
+const DEFAULTSTEP = 5
+
+// Scanning of the sequences with overlapping windows of "Window" residues, one every "Step" residues.
+// A window of 0 means no scanning
+type Scanning struct {
+	Window	int
+	Step	int
+}
+
+func (s Scanning) String() string {
+
+	if s.Window == 0 {
+		return "none"
+	}
+
+	return fmt.Sprintf("windows of %d residues, step %d", s.Window, s.Step)
+}
+
+func (s Scanning) Check() error {
+
+	if s.Window < 0 {
+		return errors.New(fmt.Sprintf("Invalid window length: %d", s.Window))
+	}
+
+	if s.Window > 0 && (s.Step < 1 || s.Step > s.Window) {
+		return errors.New(fmt.Sprintf("Invalid window step: %d (must be between 1 and the window length)", s.Step))
+	}
+
+	return nil
+}
+
+// Window of a sequence: its residues "Start" to "End" (one based, included)
+type Window struct {
+	FastaSeq
+	Parent	string
+	Start	int
+	End		int
+}
+
+// Windows of the sequence. The last window ends at the end of the sequence, so every residue is scanned,
+// and a sequence shorter than the window has only one window (the whole sequence)
+func (s Scanning) Windows(fs FastaSeq) []Window {
+
+	seqLen := fs.Len()
+	windows := []Window{}
+
+	add := func(start, end int) {
+		windows = append(windows, Window{
+			FastaSeq: FastaSeq{
+				ID: fmt.Sprintf("%s_w%d-%d", fs.ID, start, end),
+				Desc: fmt.Sprintf("parent=%s start=%d end=%d", fs.ID, start, end),
+				Seq: fs.Seq[start - 1 : end],
+			},
+			Parent: fs.ID,
+			Start: start,
+			End: end,
+		})
+	}
+
+	if seqLen == 0 {
+		return windows
+	}
+
+	if seqLen <= s.Window {
+		add(1, seqLen)
+		return windows
+	}
+
+	start := 1
+
+	for ; start + s.Window - 1 <= seqLen; start += s.Step {
+		add(start, start + s.Window - 1)
+	}
+
+	if windows[len(windows) - 1].End < seqLen {
+		add(seqLen - s.Window + 1, seqLen)
+	}
+
+	return windows
+}
+
+// Region of a sequence covered by overlapping positive windows
+type Region struct {
+	Parent		string
+	Start		int
+	End			int
+	NumWindows	int
+	Seq			string
+}
+
+// Merge the overlapping windows (in the order given by Windows) into regions of the sequence
+func MergeWindows(fs FastaSeq, windows []Window) []Region {
+
+	regions := []Region{}
+
+	for _, w := range windows {
+
+		last := len(regions) - 1
+
+		if last >= 0 && w.Start <= regions[last].End {
+
+			if w.End > regions[last].End {
+				regions[last].End = w.End
+			}
+
+			regions[last].NumWindows++
+			continue
+		}
+
+		regions = append(regions, Region{Parent: fs.ID, Start: w.Start, End: w.End, NumWindows: 1})
+	}
+
+	for i := range regions {
+		regions[i].Seq = fs.Seq[regions[i].Start - 1 : regions[i].End]
+	}
+
+	return regions
+}
+
+// Write the windows of the sequences of the input file. Returns the number of windows of each sequence
+// and the number of windows written
+func ScanFasta(inFile, outFile string, s Scanning, verbose bool) ([]int, int, error) {
+
+	return transformFasta(inFile, outFile, "windows", verbose, func(fs FastaSeq) []FastaSeq {
+
+		seqs := []FastaSeq{}
+
+		for _, w := range s.Windows(fs) {
+			seqs = append(seqs, w.FastaSeq)
+		}
+
+		return seqs
+	})
+}
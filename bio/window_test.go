@@ -0,0 +1,98 @@
+package bio
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testProtein = "ACDEFGHIKLMNPQRSTVWY"
+
+func TestWindows(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		seq			string
+		scanning	Scanning
+		expected	[][2]int	// Start and end of the windows
+	}{
+		{name: "shorter than the window", seq: "ACDEF", scanning: Scanning{Window: 10, Step: 5},
+			expected: [][2]int{{1, 5}}},
+		{name: "as long as the window", seq: testProtein[ : 10], scanning: Scanning{Window: 10, Step: 5},
+			expected: [][2]int{{1, 10}}},
+		{name: "steps up to the end", seq: testProtein[ : 10], scanning: Scanning{Window: 4, Step: 3},
+			expected: [][2]int{{1, 4}, {4, 7}, {7, 10}}},
+		{name: "last window at the end", seq: testProtein[ : 11], scanning: Scanning{Window: 4, Step: 3},
+			expected: [][2]int{{1, 4}, {4, 7}, {7, 10}, {8, 11}}},
+		{name: "adjacent windows", seq: testProtein[ : 8], scanning: Scanning{Window: 4, Step: 4},
+			expected: [][2]int{{1, 4}, {5, 8}}},
+		{name: "step of one", seq: "ACDEF", scanning: Scanning{Window: 3, Step: 1},
+			expected: [][2]int{{1, 3}, {2, 4}, {3, 5}}},
+		{name: "empty sequence", seq: "", scanning: Scanning{Window: 4, Step: 2}, expected: [][2]int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := [][2]int{}
+
+			for _, w := range tt.scanning.Windows(FastaSeq{ID: "p", Seq: tt.seq}) {
+
+				got = append(got, [2]int{w.Start, w.End})
+
+				if w.Seq != tt.seq[w.Start - 1 : w.End] || w.Parent != "p" {
+					t.Errorf("Window %s of %s: %s", w.ID, w.Parent, w.Seq)
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Windows %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMergeWindows(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		windows		[][2]int	// Start and end of the positive windows
+		expected	[]Region
+	}{
+		{name: "no windows", windows: [][2]int{}, expected: []Region{}},
+		{name: "one window", windows: [][2]int{{3, 6}},
+			expected: []Region{{Parent: "p", Start: 3, End: 6, NumWindows: 1, Seq: "DEFG"}}},
+		{name: "overlapping windows", windows: [][2]int{{1, 4}, {3, 6}},
+			expected: []Region{{Parent: "p", Start: 1, End: 6, NumWindows: 2, Seq: "ACDEFG"}}},
+		{name: "chain of overlapping windows", windows: [][2]int{{1, 4}, {3, 6}, {5, 8}},
+			expected: []Region{{Parent: "p", Start: 1, End: 8, NumWindows: 3, Seq: "ACDEFGHI"}}},
+		{name: "adjacent windows are not merged", windows: [][2]int{{1, 4}, {5, 8}},
+			expected: []Region{
+				{Parent: "p", Start: 1, End: 4, NumWindows: 1, Seq: "ACDE"},
+				{Parent: "p", Start: 5, End: 8, NumWindows: 1, Seq: "FGHI"},
+			}},
+		{name: "separate windows", windows: [][2]int{{1, 4}, {9, 12}, {11, 14}},
+			expected: []Region{
+				{Parent: "p", Start: 1, End: 4, NumWindows: 1, Seq: "ACDE"},
+				{Parent: "p", Start: 9, End: 14, NumWindows: 2, Seq: "KLMNPQ"},
+			}},
+		{name: "last window at the end", windows: [][2]int{{13, 18}, {17, 20}, {15, 20}},
+			expected: []Region{{Parent: "p", Start: 13, End: 20, NumWindows: 3, Seq: "PQRSTVWY"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			windows := []Window{}
+
+			for _, se := range tt.windows {
+				windows = append(windows, Window{Parent: "p", Start: se[0], End: se[1]})
+			}
+
+			got := MergeWindows(FastaSeq{ID: "p", Seq: testProtein}, windows)
+
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Regions %+v, expected %+v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -6,11 +6,274 @@ import (
 	. "bitbucket.org/germelcar/campred/common"
 	"bitbucket.org/germelcar/campred/bio"
 	"bitbucket.org/germelcar/campred/util"
+	"bitbucket.org/germelcar/campred/report"
 	"fmt"
+	"runtime"
+	"strings"
 	"time"
 )
 
 
+// Write the sequences with issues found by the validation (if any)
+func writeRejects(mCli *cli.Cli, v *bio.Validator) {
+
+	rejects := v.Rejects()
+
+	if len(rejects) == 0 {
+		return
+	}
+
+	InfoLog.Printf("%d sequence(s) with issues: %d stripped, %d excluded, %d rejected, %d not evaluated (see %s)",
+		len(rejects), v.Count(bio.POLICYSTRIP), v.Count(bio.POLICYEXCLUDE), v.Count(bio.POLICYREJECT),
+		v.Count(bio.NOTEVALUATED), mCli.RejectsName())
+
+	err := v.WriteRejects(mCli.RejectsName())
+
+	if err != nil {
+		WarningLog.Printf("Error while writting rejects file %s: %s", mCli.RejectsName(), err)
+	}
+}
+
+// Log the error and exit, removing the temporary files not needed for resuming the run
+func fatal(mCli *cli.Cli, err error) {
+
+	ErrorLog.Println(err)
+	mCli.CleanupFailed()
+	os.Exit(1)
+}
+
+// Combine the input files (or copy the standard input) in a single file. From here on, the input file is
+// the combination, and the samples say which of its sequences come from each input file
+func combine(mCli *cli.Cli) []bio.Sample {
+
+	StatusLog.Printf(":::%s:::", "Reading input files")
+	samples, err := bio.CombineFiles(mCli.InFiles, mCli.CombinedFile(), mCli.Verbose)
+
+	if err != nil {
+		fatal(mCli, err)
+	}
+
+	if mCli.MultiSample() {
+		InfoLog.Printf("%d samples combined in %s", len(samples), mCli.CombinedFile())
+	}
+
+	return samples
+}
+
+func removeCombined(mCli *cli.Cli) {
+
+	err := os.Remove(mCli.CombinedFile())
+
+	if err != nil {
+		WarningLog.Printf("Unable to remove %s: %s", mCli.CombinedFile(), err)
+
+	} else if mCli.Verbose {
+		InfoLog.Printf("%s removed", mCli.CombinedFile())
+	}
+}
+
+// Translate the nucleotide sequences of the input file. From here on, the input file is the file
+// with the peptides, whose IDs point back to the nucleotide sequences
+func translate(mCli *cli.Cli, samples []bio.Sample) []bio.Sample {
+
+	StatusLog.Printf(":::%s:::", "Translating sequences")
+	numPeps, totPeps, err := bio.TranslateFasta(mCli.InFile, mCli.PeptidesFile(), mCli.Translation, mCli.Verbose)
+
+	if err != nil {
+		fatal(mCli, err)
+	}
+
+	InfoLog.Printf("%d sequence(s) translated into %d peptide(s) (%s)", len(numPeps), totPeps, mCli.PeptidesFile())
+	mCli.InFile = mCli.PeptidesFile()
+
+	return bio.RemapSamples(samples, numPeps)
+}
+
+// Write the windows of the sequences of the input file. From here on, the input file is the file
+// with the windows, whose IDs point back to the scanned sequences
+func scan(mCli *cli.Cli, samples []bio.Sample) []bio.Sample {
+
+	StatusLog.Printf(":::%s:::", "Scanning sequences")
+	numWindows, totWindows, err := bio.ScanFasta(mCli.InFile, mCli.WindowsFile(), mCli.Scanning, mCli.Verbose)
+
+	if err != nil {
+		fatal(mCli, err)
+	}
+
+	InfoLog.Printf("%d sequence(s) scanned with %d window(s) (%s)", len(numWindows), totWindows, mCli.WindowsFile())
+	mCli.InFile = mCli.WindowsFile()
+
+	return bio.RemapSamples(samples, numWindows)
+}
+
+// Validate and split the input file and save the initial state of the run
+func newJobState(mCli *cli.Cli) *util.JobState {
+
+	StatusLog.Printf(":::%s:::", "Splitting sequences")
+	var fFiles []bio.FastaFile
+	v := bio.NewValidator(mCli.Validation)
+
+	// In stream mode, the sequences are read while sending them. Only count them
+	if mCli.Stream {
+
+		fFile, err := bio.StatFasta(mCli.InFile, v)
+		writeRejects(mCli, v)
+
+		if err != nil {
+			fatal(mCli, err)
+		}
+
+		fFiles = bio.PlanChunks(mCli.SplitPrefix(), fFile.NumSeqs, mCli.SplitSize(), mCli.Compression)
+
+		if mCli.Verbose {
+			InfoLog.Printf("Read %d sequences. %d requests of %d sequences (at most) each one\n",
+				fFile.NumSeqs, len(fFiles), mCli.SplitSize())
+		}
+
+	} else {
+
+		// If NumSeqs == 1 means that the entire file will be processed at once. The sequences
+		// accepted are still written to a single splitted file
+		numSeqs := mCli.SplitSize()
+
+		if numSeqs == 1 {
+
+			if mCli.Verbose {
+				InfoLog.Println("Processing the entire file")
+			}
+
+			numSeqs = 0
+		}
+
+		var tot int
+		var err error
+		fFiles, tot, err = bio.SplitFasta(mCli.InFile, mCli.SplitPrefix(), numSeqs, mCli.NumThreads, mCli.Compression, v,
+			mCli.Verbose)
+		writeRejects(mCli, v)
+
+		if err != nil && (len(fFiles) == 0 || bio.IsRejected(err)) {
+			fatal(mCli, err)
+		}
+
+		if mCli.Verbose {
+			InfoLog.Printf("Read %d sequences. Splitted in %d files of %d sequences (at most) each one\n",
+				tot, len(fFiles), mCli.SplitSize())
+		}
+
+	}
+
+	state := util.NewJobState(mCli.StateFile(), mCli.RunSettings(), v.Rejects(), fFiles)
+	err := state.Save()
+
+	if err != nil {
+		WarningLog.Printf("Error while writting state file %s: %s", mCli.StateFile(), err)
+	}
+
+	return state
+}
+
+// Sequences with issues of the run whose responses are parsed, from the rejects file given, the state file
+// of the run or its rejects file. Otherwise, the sequences not sent would be reported as failed
+func keptRejects(mCli *cli.Cli) []bio.Reject {
+
+	if mCli.RejectsFile == "" {
+
+		state, err := util.LoadJobState(mCli.StateFile())
+
+		if err == nil {
+			return state.Rejects
+		}
+	}
+
+	rejects, err := bio.ReadRejects(mCli.RejectsName())
+
+	if err == nil {
+		return rejects
+	}
+
+	// Without issues, the run writes no rejects file
+	if !os.IsNotExist(err) || mCli.RejectsFile != "" {
+		WarningLog.Printf("Error while reading rejects file %s: %s", mCli.RejectsName(), err)
+
+	} else if mCli.Verbose {
+		InfoLog.Printf("No state file (%s) nor rejects file (%s) of the run", mCli.StateFile(), mCli.RejectsName())
+	}
+
+	return nil
+}
+
+// Send the (splitted) input file to the server, resuming a previous run if requested. The sequences
+// with issues found by the validation are returned along with the results and the number of failed files
+func predict(mCli *cli.Cli) (util.Results, []bio.Reject, int) {
+
+	var state *util.JobState
+
+	// Resume a previous run: the files already processed are not sent again
+	if mCli.Resume {
+
+		StatusLog.Printf(":::%s:::", "Resuming")
+		var err error
+		state, err = util.LoadJobState(mCli.StateFile())
+
+		if os.IsNotExist(err) {
+			WarningLog.Printf("No state file %s to resume. Starting from the beginning", mCli.StateFile())
+
+		} else if err != nil {
+			fatal(mCli, err)
+
+		} else {
+			err = state.Check(mCli.RunSettings())
+
+			if err != nil {
+				fatal(mCli, err)
+			}
+
+			InfoLog.Printf("%d of %d files already processed (%d failed)", state.Count(util.STATUSDONE),
+				len(state.Chunks), state.Count(util.STATUSFAILED))
+		}
+	}
+
+	if state == nil {
+		state = newJobState(mCli)
+	}
+
+	fmt.Fprintln(Console)
+	StatusLog.Printf(":::%s:::", "Predicting")
+	predictor := util.NewCampPredictor(mCli.URL, mCli.Timeout, mCli.NumThreads, mCli.Keep, mCli.Verbose)
+
+	if mCli.Verbose {
+		InfoLog.Printf("Predicting with %s", predictor.Name())
+	}
+
+	results := util.Predict(state, predictor, mCli.Retry, mCli.ChunkSizer(), mCli.NumRequests, mCli.Algos,
+		mCli.Keep, mCli.Verbose)
+
+	return results, state.Rejects, state.Count(util.STATUSFAILED)
+}
+
+// Report the sequences out of the range of lengths, which were not sent
+func reportNotEvaluated(rejects []bio.Reject, verbose bool) {
+
+	notEval := []bio.Reject{}
+
+	for _, rej := range rejects {
+		if rej.Action == bio.NOTEVALUATED {
+			notEval = append(notEval, rej)
+		}
+	}
+
+	if len(notEval) == 0 {
+		return
+	}
+
+	InfoLog.Printf("%d sequence(s) not evaluated (length out of range)", len(notEval))
+
+	if verbose {
+		for _, rej := range notEval {
+			InfoLog.Printf("Not evaluated: %s (sequence %d, %s)", rej.ID, rej.Num, strings.Join(rej.Issues, ", "))
+		}
+	}
+}
 
 func main() {
 
@@ -22,75 +285,141 @@ func main() {
 		os.Exit(1)
 	}
 
+	if mCli.Mode == cli.MODEMOCK {
+		ErrorLog.Println(mCli.MockServer().ListenAndServe())
+		os.Exit(1)
+	}
+
 	mCli.PrintOptions()
 	start := time.Now()
-	StatusLog.Printf(":::%s:::", "Splitting sequences")
-	var preds map[int]struct{}
 
-	// If NumSeqs == 1 means that the entire file will be processed at one. No split needed
-	if mCli.NumSeqs == 1 {
+	// Limit the local work (splitting, parsing, extracting...) to the number of threads
+	runtime.GOMAXPROCS(mCli.NumThreads)
+	var results util.Results
+	var rejects []bio.Reject
+	var samples []bio.Sample
+	totFailed := 0
 
-		if mCli.Verbose {
-			InfoLog.Println("Processing the entire file")
+	// The standard input can be read only once, but the sequences are read again for the table
+	// and the output. Several input files are sent together (sharing the requests)
+	combined := mCli.InFile != mCli.InFiles[0]
+
+	if combined {
+		samples = combine(&mCli)
+	}
+
+	if mCli.Translation.Mode != bio.TRANSLATENONE {
+		samples = translate(&mCli, samples)
+	}
+
+	// The regions are reported on the sequences before scanning them
+	parentFile := mCli.InFile
+	parentSamples := samples
+
+	if mCli.Scanning.Window > 0 {
+		samples = scan(&mCli, samples)
+	}
+
+	if mCli.Mode == cli.MODEPARSE {
+		StatusLog.Printf(":::%s:::", "Parsing kept responses")
+		results, err = util.ParseKept(mCli.ChunkFiles, mCli.Algos, mCli.NumThreads, mCli.Verbose)
+
+		if err != nil {
+			fatal(&mCli, err)
 		}
 
-		fFile, err := bio.StatFasta(mCli.InFile)
+		rejects = keptRejects(&mCli)
+		reportNotEvaluated(rejects, mCli.Verbose)
+
+	} else {
+		results, rejects, totFailed = predict(&mCli)
+		reportNotEvaluated(rejects, mCli.Verbose)
+	}
+
+	// Decide which sequences are AMP once all the responses have been parsed
+	StatusLog.Printf(":::%s:::", "Applying consensus")
+	preds := util.ApplyConsensus(results, mCli.Algos, mCli.MinProbs, mCli.Consensus)
+
+	if mCli.TableFile != "" {
+
+		StatusLog.Printf(":::%s:::", "Writting table")
+		err = report.WriteTable(mCli.InFile, mCli.TableFile, mCli.Format, mCli.Algos, results, rejects,
+			samples)
 
 		if err != nil {
-			ErrorLog.Println(err)
-			os.Exit(1)
+			fatal(&mCli, err)
 		}
 
 		if mCli.Verbose {
-			InfoLog.Printf("Read %d sequences\n", fFile.NumSeqs)
+			InfoLog.Printf("Results of %d sequence(s) written to %s", len(results), mCli.TableFile)
 		}
+	}
 
-		fmt.Println("")
-		StatusLog.Printf(":::%s:::", "Predicting")
-		preds = util.Predict([]bio.FastaFile{fFile}, mCli.NumSend, mCli.Algos, mCli.Keep, mCli.Verbose)
+	if mCli.Scanning.Window > 0 {
+
+		StatusLog.Printf(":::%s:::", "Merging windows")
+		totRegions, err := report.WriteRegions(parentFile, mCli.RegionsName(), mCli.Format, mCli.Scanning, preds,
+			parentSamples)
 
-		// If there was an error writting the sequences, then inform it, otherwise, append the last written file
-		// to the slice of files created from the splitting
 		if err != nil {
-			WarningLog.Println(err)
+			fatal(&mCli, err)
 		}
 
-	} else {
-		fFiles, tot, err := bio.SplitFasta(mCli.InFile, mCli.OutFile, mCli.NumSeqs, mCli.Verbose)
+		InfoLog.Printf("%d AMP region(s) written to %s", totRegions, mCli.RegionsName())
+	}
 
-		if err != nil && len(fFiles) == 0 {
-			ErrorLog.Println(err)
-			os.Exit(1)
-		}
+	if mCli.MultiSample() {
 
-		if mCli.Verbose {
-			InfoLog.Printf("Read %d sequences. Splitted in %d files of %d sequences (at most) each one\n",
-				tot, len(fFiles), mCli.NumSeqs)
-		}
+		StatusLog.Printf(":::%s:::", "Writting summary")
+		err = report.WriteSummary(mCli.SummaryName(), mCli.Format, samples, results, rejects, preds)
 
-		fmt.Println("")
-		StatusLog.Printf(":::%s:::", "Predicting")
-		preds = util.Predict(fFiles, mCli.NumSend, mCli.Algos, mCli.Keep, mCli.Verbose)
+		if err != nil {
+			fatal(&mCli, err)
+		}
 
+		InfoLog.Printf("Summary of %d samples written to %s", len(samples), mCli.SummaryName())
 	}
 
+	// The sequences predicted as AMP are optional when the table was requested
 	totPreds := len(preds)
 
-	if totPreds == 0 {
+	if mCli.OutFile == "" {
+		if mCli.Verbose {
+			InfoLog.Printf("%d sequence(s) predicted as AMP. No output file to extract them", totPreds)
+		}
+
+	} else if totPreds == 0 {
 		StatusLog.Println(":::Extracting sequences:::")
 		InfoLog.Println("No sequences to extract.")
 
 	} else {
 
 		StatusLog.Printf(":::%s %d %s:::", "Extracting", len(preds), "predicteds as AMP")
-		err = bio.ExtractSeqs(mCli.InFile, mCli.OutFile, preds, mCli.Verbose)
+
+		// With several samples, their sequences are also written to a file per sample
+		if mCli.MultiSample() && mCli.OutFile != bio.STDIO {
+			err = bio.ExtractSamples(mCli.InFile, mCli.OutFile, samples, preds, mCli.Verbose)
+		} else {
+			err = bio.ExtractSeqs(mCli.InFile, mCli.OutFile, preds, mCli.Verbose)
+		}
 
 		if err != nil {
-			ErrorLog.Println(err)
-			os.Exit(1)
+			fatal(&mCli, err)
 		}
 	}
 
-	fmt.Printf("\n\nElapsed: %s\n", time.Since(start))
+	// The combination is only a working copy of the inputs, made again when the run is resumed
+	if combined {
+		removeCombined(&mCli)
+	}
+
+	// The splitted files of the failed requests are kept for resuming the run
+	if totFailed > 0 {
+		mCli.CleanupFailed()
+	} else {
+		mCli.Cleanup()
+	}
+
+	fmt.Fprintf(Console, "\n\nElapsed: %s\n", time.Since(start))
 }
 
@@ -4,20 +4,89 @@ import (
 	"fmt"
 	flag "github.com/spf13/pflag"
 	"os"
+	"path/filepath"
 	"errors"
 	"runtime"
 	. "bitbucket.org/germelcar/campred/common"
+	"bitbucket.org/germelcar/campred/bio"
+	"bitbucket.org/germelcar/campred/report"
+	"bitbucket.org/germelcar/campred/mock"
+	"bitbucket.org/germelcar/campred/util"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MODEPREDICT	= "predict"
+	MODEPARSE	= "parse"	// Parse the kept responses (".camp" files) without sending anything
+	MODEMOCK	= "mock-server"	// Serve a local stand-in of the CAMP server (see mock.Server)
+
+	DEFAULTPREFIX	= "campred"	// Prefix for the files when the output is the standard output
 )
 
 type Cli struct {
 
-	InFile     	string
+	Mode		string
+	InFile     	string		// File whose sequences are sent (the input, or the combination of the inputs)
+	InFiles		[]string
 	OutFile    	string
+	ChunkFiles	[]string
+	TableFile	string
+	Format		string
 	NumSeqs    	int
+	Adaptive	bool
+	MinNSeqs	int
+	MaxNSeqs	int
 	NumThreads 	int
 	NumSend		int
+	NumRequests	int
+	URL			string
+	Timeout		time.Duration
+	Backoff		time.Duration
+	MaxBackoff	time.Duration
+	Jitter		float64
+	BisectTries	int
+	Retry		util.RetryPolicy
+	ConfigFile	string
 	Algos      	uint8
+	MinProb		string
+	MinProbs	util.MinProbs
+	ConsRule	string
+	Weight		string
+	MinScore	float64
+	Consensus	util.Consensus
+	Sanitize	string
+	MinLen		int
+	MaxLen		int
+	Validation	bio.Validation
+	RejectsFile	string
+	Translate	string
+	GeneticCode	int
+	OrfMinLen	int
+	OrfMaxLen	int
+	Translation	bio.Translation
+	Window		int
+	Step		int
+	Scanning	bio.Scanning
+	RegionsFile	string
+	SummaryFile	string
+	Compression	string
+	Listen		string
+	MockRule	string
+	MockSeed	int64
+	MockFailRate	float64
+	MockFailures	string
+	MockDelay	time.Duration
+	MockMaxSeqs	int
+	MockSeqDelay	time.Duration
+	MockPoison	string
+	Prefix		string
+	tmpDir		string	// Directory of the files of the run when the results go to the standard output
 	Keep       	bool
+	Resume		bool
+	Stream		bool
 	Verbose    	bool
 }
 
@@ -26,17 +95,96 @@ var cli Cli
 func init() {
 	flag.BoolVarP(&cli.Verbose, "verbose", "v", false, "Show extra information")
 	flag.BoolVarP(&cli.Keep,"keep", "k", true, "Keep intermediate file")
-	flag.StringVarP(&cli.InFile, "input", "i", "", "Input filename")
-	flag.StringVarP(&cli.OutFile, "output", "o", "", "Output filename")
+	flag.StringVar(&cli.Prefix, "prefix", "",
+		"Prefix of the splitted and intermediate files (default: the output or the table filename, or a temporary directory removed at exit if both are the standard output)")
+	flag.StringVar(&cli.Compression, "compress", bio.NOCOMPRESSION,
+		"Compression of the splitted files: none, gz or zst. The output is compressed by its extension (.gz or .zst)")
+	flag.BoolVar(&cli.Resume, "resume", false, "Resume a previous run skipping the files already processed")
+	flag.BoolVar(&cli.Stream, "stream", false,
+		"Send the sequences from memory. The splitted files are only written with --keep")
+	flag.StringArrayVarP(&cli.InFiles, "input", "i", nil,
+		"Input filename (- for the standard input), glob (e.g. 'data/*.fa.gz') or directory (its fasta files). " +
+		"Repeat it for several samples")
+	flag.StringVarP(&cli.OutFile, "output", "o", "", "Output filename (sequences predicted as AMP). - for the standard output")
+	flag.StringVar(&cli.TableFile, "table", "",
+		"Table filename (results of each algorithm per sequence). - for the standard output")
+	flag.StringVar(&cli.Format, "format", report.TSV, "Format of the table (csv or tsv)")
 	flag.IntVarP(&cli.NumSeqs, "nseqs", "n", 1,
 		"Split in multiple parts of `n` parts each one")
-	flag.IntVarP(&cli.NumThreads, "threads", "t", runtime.NumCPU(), "Number of threads")
-	flag.IntVarP(&cli.NumSend, "send", "s", MAXNUMTRIESSEND,
-		fmt.Sprintf("%s %d)", "Max number of times to send each request (max.", MAXNUMTRIESSEND))
+	flag.BoolVar(&cli.Adaptive, "adaptive", false,
+		"Tune the sequences per request between --min-nseqs and --max-nseqs starting with --nseqs: less when the " +
+		"requests time out or come back incomplete, more when they are fast. The files are splitted by --max-nseqs")
+	flag.IntVar(&cli.MinNSeqs, "min-nseqs", util.DEFAULTMINCHUNK, "Minimum sequences per request (--adaptive)")
+	flag.IntVar(&cli.MaxNSeqs, "max-nseqs", util.DEFAULTMAXCHUNK, "Maximum sequences per request (--adaptive)")
+	flag.StringVar(&cli.MinProb, "min-prob", "",
+		"Minimum probability per algorithm to count a sequence as AMP (e.g. `svm=0.8,rf=0.7`)")
+	flag.StringVar(&cli.ConsRule, "consensus", util.CONSENSUSALL,
+		"Consensus to count a sequence as AMP: all, any, majority, at-least-N or weighted")
+	flag.StringVar(&cli.Weight, "weights", "",
+		"Weight per algorithm for the weighted consensus (e.g. `svm=2,rf=1`). Default 1")
+	flag.Float64Var(&cli.MinScore, "min-score", util.DEFAULTMINSCORE,
+		"Minimum score (0 to 1) to count a sequence as AMP with the weighted consensus")
+	flag.StringVar(&cli.Sanitize, "sanitize", "",
+		"Policy (strip, exclude or reject) per issue of the sequences: empty, lowercase, stop, gap, ambiguous, " +
+		"nucleotide or invalid (e.g. `ambiguous=strip,nucleotide=reject`). Default " + bio.DefaultPolicies().String())
+	flag.IntVar(&cli.MinLen, "min-len", 0,
+		"Minimum length of the sequences. The shorter ones are not evaluated (not sent). 0 for no limit")
+	flag.IntVar(&cli.MaxLen, "max-len", 0,
+		"Maximum length of the sequences. The longer ones are not evaluated (not sent). 0 for no limit")
+	flag.StringVar(&cli.RejectsFile, "rejects", "",
+		"Rejects filename (sequences with issues and what was done with them). Default OUTPUT.rejects.tsv")
+	flag.StringVar(&cli.Translate, "translate", bio.TRANSLATENONE,
+		"Translate the nucleotide sequences of the input: none, frames (six-frame translation) or orf (open reading frames)")
+	flag.IntVar(&cli.GeneticCode, "genetic-code", bio.DEFAULTGENETICCODE,
+		fmt.Sprintf("Genetic code (NCBI translation table) for translating: %v", bio.GeneticCodeIDs()))
+	flag.IntVar(&cli.OrfMinLen, "orf-min-len", bio.DEFAULTORFMINLEN,
+		"Minimum length (amino acids) of the translated peptides")
+	flag.IntVar(&cli.OrfMaxLen, "orf-max-len", 0, "Maximum length (amino acids) of the translated peptides. 0 for no limit")
+	flag.IntVar(&cli.Window, "window", 0,
+		"Scan the sequences with overlapping windows of `n` residues and report the AMP regions. 0 for no scanning")
+	flag.IntVar(&cli.Step, "step", bio.DEFAULTSTEP, "Residues between the starts of two consecutive windows")
+	flag.StringVar(&cli.RegionsFile, "regions", "",
+		"Regions filename (AMP regions of each sequence found by scanning). Default OUTPUT.regions.FORMAT")
+	flag.StringVar(&cli.SummaryFile, "summary", "",
+		"Summary filename (sequences and AMP per sample) when there are several inputs. Default OUTPUT.summary.FORMAT")
+	flag.IntVarP(&cli.NumThreads, "threads", "t", runtime.NumCPU(),
+		"Number of threads for the local work (splitting, parsing responses, etc.)")
+	flag.IntVarP(&cli.NumSend, "send", "s", MAXNUMTRIESSEND, "Max number of times to send each request")
+	flag.IntVarP(&cli.NumRequests, "requests", "r", MAXREQUESTS, "Number of requests sent concurrently")
+	flag.StringVar(&cli.URL, "url", CAMPREDURL, "URL of the CAMP server (prediction form)")
+	flag.DurationVar(&cli.Timeout, "timeout", REQUESTTIMEOUT, "Timeout per request (e.g. 90s, 10m)")
+	flag.DurationVar(&cli.Backoff, "backoff", util.DEFAULTBACKOFF,
+		"Delay before resending a failed request. Doubled after each failed try")
+	flag.DurationVar(&cli.MaxBackoff, "max-backoff", util.DEFAULTMAXBACKOFF, "Maximum delay before resending a request")
+	flag.Float64Var(&cli.Jitter, "jitter", util.DEFAULTJITTER, "Random fraction (0 to 1) of the delay before resending")
+	flag.IntVar(&cli.BisectTries, "bisect-tries", util.DEFAULTBISECTTRIES,
+		"Max number of times to send each half of a request that keeps failing, which is splitted until the failing sequences are found (0 for not splitting)")
+	flag.StringVar(&cli.Listen, "listen", mock.DEFAULTADDR, "Address of the mock server (mock-server command)")
+	flag.StringVar(&cli.MockRule, "mock-rule", mock.RULERANDOM,
+		"How the mock server classifies the sequences: random (by the seed) or charge (cationic and hydrophobic are AMP)")
+	flag.Int64Var(&cli.MockSeed, "mock-seed", 1, "Seed of the answers and the failures of the mock server")
+	flag.Float64Var(&cli.MockFailRate, "mock-fail-rate", 0, "Fraction (0 to 1) of the requests failed by the mock server")
+	flag.StringVar(&cli.MockFailures, "mock-failures", strings.Join(mock.FAILURES, ","),
+		"How the requests of the mock server fail: empty (tables), 500, 503, slow, truncated (page) or partial (tables)")
+	flag.DurationVar(&cli.MockDelay, "mock-delay", mock.DEFAULTDELAY, "Delay of the slow answers of the mock server")
+	flag.DurationVar(&cli.MockSeqDelay, "mock-seq-delay", 0,
+		"Time per sequence of the answers of the mock server (e.g. 100ms), so the bigger requests take longer")
+	flag.IntVar(&cli.MockMaxSeqs, "mock-max-seqs", 0,
+		"Sequences per request accepted by the mock server. The requests with more are rejected. 0 for no limit")
+	flag.StringVar(&cli.MockPoison, "mock-poison", "",
+		"Residues that make the mock server fail (500) every request with a sequence that has them")
+	flag.StringVarP(&cli.ConfigFile, "config", "c", "",
+		"Configuration file (JSON) with the values of the flags (e.g. {\"url\": \"...\", \"requests\": 4})")
 
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stdout, "Usage: %s FLAGS ARGUMENTS\n\n", os.Args[0])
+		fmt.Fprintf(os.Stdout, "Usage: %s FLAGS ARGUMENTS\n", os.Args[0])
+		fmt.Fprintf(os.Stdout, "       %s parse FLAGS ARGUMENTS SPLITTED_FILES\n", os.Args[0])
+		fmt.Fprintf(os.Stdout, "       %s mock-server FLAGS\n\n", os.Args[0])
 		fmt.Fprintf(os.Stdout, "%s v%s\n\n", "CAMPRED - CAMP AMP PREDiction", VERSION)
+		fmt.Fprintf(os.Stdout, "%s:\n", "Commands")
+		fmt.Fprintf(os.Stdout, "  %-21s %s\n", MODEPARSE, "Parse the kept responses (.camp) of the splitted files. No requests sent")
+		fmt.Fprintf(os.Stdout, "  %-21s %s\n", MODEMOCK, "Serve a local stand-in of the CAMP server for testing (see --url)")
+		fmt.Fprintln(os.Stdout, "")
 		fmt.Fprintf(os.Stdout, "%s:\n", "Flags")
 		usages := flag.CommandLine.FlagUsages()
 		fmt.Fprint(os.Stdout, usages)
@@ -63,7 +211,50 @@ func (c *Cli) Parse() (bool, error) {
 		return false, errors.New("No arguments detected. Provide at least one")
 	}
 
-	for _, a := range flag.Args() {
+	// The values of the configuration file are used for the flags not given
+	if c.ConfigFile != "" {
+
+		err := loadConfig(c.ConfigFile)
+
+		if err != nil {
+			return false, err
+		}
+
+		*c = cli
+	}
+
+	// The standard output only has the results
+	if c.OutFile == bio.STDIO || c.TableFile == bio.STDIO {
+		MessagesToStderr()
+	}
+
+	args := flag.Args()
+	c.Mode = MODEPREDICT
+
+	if len(args) > 0 && args[0] == MODEPARSE {
+		c.Mode = MODEPARSE
+		args = args[1:]
+	}
+
+	// The mock server needs no input, output nor algorithms
+	if len(args) > 0 && args[0] == MODEMOCK {
+
+		c.Mode = MODEMOCK
+
+		for _, a := range args[1:] {
+			WarningLog.Printf("%s: %s", "Unrecognized argument", a)
+		}
+
+		err := c.MockServer().Check()
+
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	for _, a := range args {
 
 		switch a {
 
@@ -83,7 +274,12 @@ func (c *Cli) Parse() (bool, error) {
 			c.Algos |= DA
 
 		default:
-			WarningLog.Printf("%s: %s", "Unrecognized algorithm argument", a)
+			// In parse mode, the arguments other than the algorithms are the splitted files
+			if c.Mode == MODEPARSE {
+				c.ChunkFiles = append(c.ChunkFiles, a)
+			} else {
+				WarningLog.Printf("%s: %s", "Unrecognized algorithm argument", a)
+			}
 
 		}
 	}
@@ -92,6 +288,97 @@ func (c *Cli) Parse() (bool, error) {
 		return false, errors.New("No valid algorithms provided. Provide at lest one")
 	}
 
+	if c.Mode == MODEPARSE && len(c.ChunkFiles) == 0 {
+		return false, errors.New("No splitted files provided to parse their responses. Provide at least one")
+	}
+
+	// Check the minimum probabilities per algorithm
+	minProbs, err := parseMinProbs(c.MinProb)
+
+	if err != nil {
+		return false, err
+	}
+
+	c.MinProbs = minProbs
+
+	// Check the consensus between algorithms
+	c.Consensus, err = util.ParseConsensus(c.ConsRule, c.Algos)
+
+	if err != nil {
+		return false, err
+	}
+
+	if c.Consensus.Rule == util.CONSENSUSWEIGHTED {
+
+		weights, err := parseAlgoValues(c.Weight, "weight")
+
+		if err != nil {
+			return false, err
+		}
+
+		for algo, w := range weights {
+			if w < 0 {
+				return false, errors.New(fmt.Sprintf("Invalid weight for %s: %v (must be positive)", AlgoName(algo), w))
+			}
+		}
+
+		if c.MinScore < 0 || c.MinScore > 1 {
+			return false, errors.New(fmt.Sprintf("Invalid minimum score: %v (must be between 0 and 1)", c.MinScore))
+		}
+
+		c.Consensus.Weights = weights
+		c.Consensus.MinScore = c.MinScore
+	}
+
+
+	// Check the validation of the sequences
+	policies, err := bio.ParsePolicies(c.Sanitize)
+
+	if err != nil {
+		return false, err
+	}
+
+	if c.MinLen < 0 || c.MaxLen < 0 || (c.MaxLen > 0 && c.MaxLen < c.MinLen) {
+		return false, errors.New(fmt.Sprintf("Invalid range of lengths: %d to %d", c.MinLen, c.MaxLen))
+	}
+
+	c.Validation = bio.Validation{Policies: policies, MinLen: c.MinLen, MaxLen: c.MaxLen}
+
+	// Check the translation of nucleotide sequences
+	c.Translation = bio.Translation{Mode: strings.ToLower(c.Translate), Code: c.GeneticCode, MinLen: c.OrfMinLen,
+		MaxLen: c.OrfMaxLen}
+	err = c.Translation.Check()
+
+	if err != nil {
+		return false, err
+	}
+
+	// Check the scanning with windows
+	c.Scanning = bio.Scanning{Window: c.Window, Step: c.Step}
+	err = c.Scanning.Check()
+
+	if err != nil {
+		return false, err
+	}
+
+	// Check the compression of the splitted files and the output
+	c.Compression = strings.ToLower(c.Compression)
+	err = bio.CheckCompression(c.Compression)
+
+	if err != nil {
+		return false, err
+	}
+
+	err = bio.CheckCompression(bio.CompressionByName(c.OutFile))
+
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("Output %s: %s", c.OutFile, err))
+	}
+
+	// In stream mode, the splitted files are only written if asked explicitly
+	if c.Stream && !flag.CommandLine.Changed("keep") {
+		c.Keep = false
+	}
 
 	// Check the number of parts
 	if c.NumSeqs < 1 {
@@ -99,6 +386,29 @@ func (c *Cli) Parse() (bool, error) {
 		c.NumSeqs = 1
 	}
 
+	// Check the bounds of the sequences per request (adaptive)
+	if c.Adaptive {
+
+		if c.MinNSeqs < 1 {
+			WarningLog.Printf("Invalid minimum sequences per request: %d. Set to %d", c.MinNSeqs, util.DEFAULTMINCHUNK)
+			c.MinNSeqs = util.DEFAULTMINCHUNK
+		}
+
+		if c.MaxNSeqs < c.MinNSeqs {
+			WarningLog.Printf("Maximum sequences per request (%d) less than the minimum. Set to %d", c.MaxNSeqs, c.MinNSeqs)
+			c.MaxNSeqs = c.MinNSeqs
+		}
+
+		if c.NumSeqs < c.MinNSeqs {
+			WarningLog.Printf("Sequences per request (%d) less than the minimum. Set to %d", c.NumSeqs, c.MinNSeqs)
+			c.NumSeqs = c.MinNSeqs
+
+		} else if c.NumSeqs > c.MaxNSeqs {
+			WarningLog.Printf("Sequences per request (%d) greater than the maximum. Set to %d", c.NumSeqs, c.MaxNSeqs)
+			c.NumSeqs = c.MaxNSeqs
+		}
+	}
+
 	// Check the number of threads
 	if c.NumThreads <= 0 || c.NumThreads > runtime.NumCPU() {
 		WarningLog.Printf("Invalid number of threads: %d. Set to %d", c.NumThreads, runtime.NumCPU())
@@ -106,75 +416,523 @@ func (c *Cli) Parse() (bool, error) {
 	}
 
 	// Check the number of times to resend a request
-	if c.NumSend <= 0 || c.NumSend > MAXNUMTRIESSEND {
+	if c.NumSend <= 0 {
 		WarningLog.Printf("Invalid number for resend a request: %d. Set to %d", c.NumSend, MAXNUMTRIESSEND)
 		c.NumSend = MAXNUMTRIESSEND
 	}
 
-	// Check if input file name exists
-	if c.InFile == "" {
+	// Check the number of requests sent concurrently
+	if c.NumRequests <= 0 {
+		WarningLog.Printf("Invalid number of concurrent requests: %d. Set to %d", c.NumRequests, MAXREQUESTS)
+		c.NumRequests = MAXREQUESTS
+	}
+
+	// Check the timeout per request
+	if c.Timeout <= 0 {
+		WarningLog.Printf("Invalid timeout per request: %s. Set to %s", c.Timeout, REQUESTTIMEOUT)
+		c.Timeout = REQUESTTIMEOUT
+	}
+
+	// Check the delays before resending a request
+	if c.Backoff < 0 {
+		WarningLog.Printf("Invalid delay before resending: %s. Set to %s", c.Backoff, util.DEFAULTBACKOFF)
+		c.Backoff = util.DEFAULTBACKOFF
+	}
+
+	if c.MaxBackoff < c.Backoff {
+		WarningLog.Printf("Maximum delay before resending (%s) less than the delay. Set to %s", c.MaxBackoff, c.Backoff)
+		c.MaxBackoff = c.Backoff
+	}
+
+	if c.Jitter < 0 || c.Jitter > 1 {
+		WarningLog.Printf("Invalid jitter: %v. Set to %v", c.Jitter, util.DEFAULTJITTER)
+		c.Jitter = util.DEFAULTJITTER
+	}
+
+	if c.BisectTries < 0 {
+		WarningLog.Printf("Invalid number of times to send the halves of a failed request: %d. Set to %d",
+			c.BisectTries, util.DEFAULTBISECTTRIES)
+		c.BisectTries = util.DEFAULTBISECTTRIES
+	}
+
+	c.Retry = util.RetryPolicy{MaxTries: c.NumSend, BaseDelay: c.Backoff, MaxDelay: c.MaxBackoff, Jitter: c.Jitter,
+		BisectTries: c.BisectTries}
+
+	// Check the URL of the server
+	u, err := url.Parse(c.URL)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false, errors.New(fmt.Sprintf("%s: %s", "Invalid server URL", c.URL))
+	}
+
+	// Check the input files, expanding the globs and the directories
+	if len(c.InFiles) == 0 {
 		return false, errors.New("input filename is empty")
 	}
 
-	_, err := os.Stat(c.InFile)
+	c.InFiles, err = bio.ExpandInputs(c.InFiles)
 
 	if err != nil {
-		if os.IsNotExist(err) {
-			return false, errors.New(fmt.Sprintf("%s: %s", "Unable to find file", c.InFile))
-		}
+		return false, err
+	}
+
+	// At least one output is needed: the AMP sequences and/or the table
+	if c.OutFile == "" && c.TableFile == "" {
+		return false, errors.New("output and table filenames are empty. Provide at least one")
+	}
+
+	if !report.ValidFormat(c.Format) {
+		return false, errors.New(fmt.Sprintf("%s: %s", "Invalid table format", c.Format))
+	}
+
+	if c.OutFile == bio.STDIO && c.TableFile == bio.STDIO {
+		return false, errors.New("output and table can not be both the standard output (-)")
 	}
 
 	// Check write permissions
-	fh, err := os.Create(c.OutFile)
+	for _, out := range []string{c.OutFile, c.TableFile, c.Prefix} {
+
+		if out == "" || out == bio.STDIO {
+			continue
+		}
+
+		err = checkWritable(out)
+
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = c.makeTmpDir()
 
 	if err != nil {
 		return false, err
 	}
 
+	// Several inputs (samples) are combined in a single file, and so is the standard input, which is
+	// checked when it is read
+	c.InFile = c.InFiles[0]
+
+	if c.MultiSample() || c.InFile == bio.STDIO {
+		c.InFile = c.CombinedFile()
+	}
+
+	return true, nil // all OK
+}
+
+// Parse values per algorithm with the form "algo=value,algo=value" (e.g. "svm=0.8,rf=0.7")
+func parseAlgoValues(value, what string) (map[uint8]float64, error) {
+
+	values := make(map[uint8]float64)
+
+	if value == "" {
+		return values, nil
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+
+		fields := strings.Split(pair, "=")
+
+		if len(fields) != 2 {
+			return nil, errors.New(fmt.Sprintf("Invalid %s: %s", what, pair))
+		}
+
+		algo, ok := AlgoByName(strings.TrimSpace(fields[0]))
+
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Unrecognized algorithm for %s: %s", what, fields[0]))
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid %s for %s: %s", what, AlgoName(algo), fields[1]))
+		}
+
+		values[algo] = v
+	}
+
+	return values, nil
+}
+
+// Parse the minimum probabilities (e.g. "svm=0.8,rf=0.7")
+func parseMinProbs(value string) (util.MinProbs, error) {
+
+	values, err := parseAlgoValues(value, "minimum probability")
+
+	if err != nil {
+		return nil, err
+	}
+
+	minProbs := make(util.MinProbs)
+
+	for algo, prob := range values {
+
+		// ANN gives no probability, so its class is always used
+		if algo == ANN {
+			WarningLog.Printf("%s. %s", "ANN has no probabilities", "Its minimum probability is ignored")
+			continue
+		}
+
+		if prob < 0 || prob > 1 {
+			return nil, errors.New(fmt.Sprintf("Invalid minimum probability for %s: %v (must be between 0 and 1)",
+				AlgoName(algo), prob))
+		}
+
+		minProbs[algo] = prob
+	}
+
+	return minProbs, nil
+}
+
+func checkWritable(fileName string) error {
+
+	fh, err := os.Create(fileName)
+
+	if err != nil {
+		return err
+	}
+
 	fh.Close()
-	err = os.Remove(c.OutFile)
+
+	return os.Remove(fileName)
+}
+
+// Prefix for the splitted files. The one given (see --prefix), the output filename if any, otherwise,
+// the table filename. If both are the standard output, the files are written in a temporary directory
+func (c *Cli) SplitPrefix() string {
+
+	if c.Prefix != "" {
+		return c.Prefix
+	}
+
+	for _, out := range []string{c.OutFile, c.TableFile} {
+		if out != "" && out != bio.STDIO {
+			return out
+		}
+	}
+
+	return filepath.Join(c.tmpDir, DEFAULTPREFIX)
+}
+
+// Prefix of the results without a filename of their own (regions, summary) and of the files for resuming
+// the run (state, rejects). The one of the splitted files, unless they are in the temporary directory,
+// which is removed at exit
+func (c *Cli) resultPrefix() string {
+
+	if c.tmpDir != "" {
+		return DEFAULTPREFIX
+	}
+
+	return c.SplitPrefix()
+}
+
+// Without output files nor prefix, the splitted files go to a temporary directory. A resumed run takes
+// the directory of the run it resumes (see CleanupFailed)
+func (c *Cli) makeTmpDir() error {
+
+	if c.Prefix != "" || (c.OutFile != "" && c.OutFile != bio.STDIO) ||
+			(c.TableFile != "" && c.TableFile != bio.STDIO) {
+		return nil
+	}
+
+	if c.Resume {
+
+		state, err := util.LoadJobState(DEFAULTPREFIX + ".state.json")
+
+		// Only a directory made by a previous run, which is removed at exit
+		if err == nil && len(state.Chunks) > 0 {
+
+			dir := filepath.Dir(state.Chunks[0].FileName)
+			info, err := os.Stat(dir)
+
+			if err == nil && info.IsDir() && filepath.Dir(dir) == filepath.Clean(os.TempDir()) &&
+					strings.HasPrefix(filepath.Base(dir), DEFAULTPREFIX) {
+				c.tmpDir = dir
+				return nil
+			}
+		}
+	}
+
+	var err error
+	c.tmpDir, err = os.MkdirTemp("", DEFAULTPREFIX)
+
+	return err
+}
+
+// Remove the temporary directory of the run, if any (see SplitPrefix)
+func (c *Cli) Cleanup() {
+
+	if c.tmpDir == "" {
+		return
+	}
+
+	err := os.RemoveAll(c.tmpDir)
 
 	if err != nil {
-		return false, err
+		WarningLog.Printf("Unable to remove %s: %s", c.tmpDir, err)
+
+	} else if c.Verbose {
+		InfoLog.Printf("%s removed", c.tmpDir)
 	}
+}
 
-	return true, nil // all OK
+// After a failed run, the splitted files of the temporary directory are kept for resuming it (see --resume),
+// but not the copy of the input, which is made again. Without state file, there is nothing to resume
+func (c *Cli) CleanupFailed() {
+
+	if c.tmpDir == "" {
+		return
+	}
+
+	if _, err := os.Stat(c.StateFile()); err != nil {
+		c.Cleanup()
+		return
+	}
+
+	err := os.Remove(c.CombinedFile())
+
+	if err != nil && !os.IsNotExist(err) {
+		WarningLog.Printf("Unable to remove %s: %s", c.CombinedFile(), err)
+	}
+
+	InfoLog.Printf("Splitted files kept in %s for resuming the run (see --resume)", c.tmpDir)
+}
+
+// Prefix of the files of the run (state, rejects...). When parsing, the one of the splitted files given,
+// which can differ from the current outputs
+func (c *Cli) RunPrefix() string {
+
+	if c.Mode == MODEPARSE && len(c.ChunkFiles) > 0 {
+		if prefix := util.ChunkPrefix(c.ChunkFiles[0]); prefix != "" {
+			return prefix
+		}
+	}
+
+	return c.resultPrefix()
+}
+
+// Whether there are several input files (samples)
+func (c *Cli) MultiSample() bool {
+	return len(c.InFiles) > 1
+}
+
+// Copy of the standard input or combination of the input files of the samples (see --input). It is
+// compressed as the first compressed input (gzip for bzip2, which can not be written) or, if none is,
+// as the splitted files
+func (c *Cli) CombinedFile() string {
+
+	name := c.SplitPrefix() + ".input.fasta"
+
+	for _, inFile := range c.InFiles {
+
+		switch bio.CompressionByName(inFile) {
+
+		case bio.GZIP, bio.BZIP2:
+			return name + "." + bio.GZIP
+
+		case bio.ZSTD:
+			return name + "." + bio.ZSTD
+
+		}
+	}
+
+	return c.compressedName(name)
+}
+
+// File with the sequences with issues found by the validation
+func (c *Cli) RejectsName() string {
+
+	if c.RejectsFile != "" {
+		return c.RejectsFile
+	}
+
+	return c.RunPrefix() + ".rejects.tsv"
+}
+
+// File with the peptides translated from the nucleotide sequences of the input (see --translate)
+func (c *Cli) PeptidesFile() string {
+	return c.compressedName(c.SplitPrefix() + ".peptides.fasta")
+}
+
+// File with the windows of the sequences of the input (see --window)
+func (c *Cli) WindowsFile() string {
+	return c.compressedName(c.SplitPrefix() + ".windows.fasta")
+}
+
+// Name of an intermediate file compressed as the splitted files (see --compress)
+func (c *Cli) compressedName(fileName string) string {
+
+	if c.Compression != bio.NOCOMPRESSION && c.Compression != "" {
+		return fileName + "." + c.Compression
+	}
+
+	return fileName
+}
+
+// File with the AMP regions found by scanning
+func (c *Cli) RegionsName() string {
+
+	if c.RegionsFile != "" {
+		return c.RegionsFile
+	}
+
+	return c.resultPrefix() + ".regions." + c.Format
+}
+
+// File with the summary per sample
+func (c *Cli) SummaryName() string {
+
+	if c.SummaryFile != "" {
+		return c.SummaryFile
+	}
+
+	return c.resultPrefix() + ".summary." + c.Format
+}
+
+// Stand-in of the CAMP server given by the mock flags
+func (c *Cli) MockServer() *mock.Server {
+
+	failures := []string{}
+
+	for _, f := range strings.Split(c.MockFailures, ",") {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			failures = append(failures, f)
+		}
+	}
+
+	srv := mock.NewServer(c.Listen, strings.ToLower(c.MockRule), c.MockSeed, c.MockFailRate, failures, c.MockDelay,
+		c.Verbose)
+	srv.MaxSeqs = c.MockMaxSeqs
+	srv.SeqDelay = c.MockSeqDelay
+	srv.Poison = strings.ToUpper(c.MockPoison)
+
+	return srv
+}
+
+// Sequences of each splitted file. In adaptive mode, the files are sent in requests of the tuned size,
+// so they have the maximum size
+func (c *Cli) SplitSize() int {
+
+	if c.Adaptive {
+		return c.MaxNSeqs
+	}
+
+	return c.NumSeqs
+}
+
+// Size of the requests tuned by the server behaviour (nil if not in adaptive mode)
+func (c *Cli) ChunkSizer() *util.ChunkSizer {
+
+	if !c.Adaptive {
+		return nil
+	}
+
+	return util.NewChunkSizer(c.NumSeqs, c.MinNSeqs, c.MaxNSeqs, c.Timeout)
+}
+
+// Settings of the run that are saved in its state (see util.JobState)
+func (c *Cli) RunSettings() util.RunSettings {
+	return util.RunSettings{
+		InFile: c.InFile,
+		InFiles: c.InFiles,
+		Algos: c.Algos,
+		ChunkSize: c.SplitSize(),
+		Stream: c.Stream,
+		Translation: c.Translation,
+		Scanning: c.Scanning,
+		Validation: c.Validation,
+	}
+}
+
+// File with the state of the run (next to the output) for resuming it
+func (c *Cli) StateFile() string {
+	return c.RunPrefix() + ".state.json"
 }
 
 func (c *Cli) PrintOptions() {
 
-	fmt.Println("---------------------------- CONFIGURATION ----------------------------")
-	fmt.Printf("Input file: %s\n", cli.InFile)
-	fmt.Printf("Output file: %s\n", cli.OutFile)
-	fmt.Printf("Number of sequences to split: %d\n", c.NumSeqs)
-	fmt.Printf("Number of threads: %d\n", c.NumThreads)
-	fmt.Print("Algorithms: ")
+	fmt.Fprintln(Console, "---------------------------- CONFIGURATION ----------------------------")
+	fmt.Fprintf(Console, "Mode: %s\n", c.Mode)
+	fmt.Fprintf(Console, "Input file(s): %s\n", strings.Join(c.InFiles, ", "))
+	fmt.Fprintf(Console, "Output file: %s\n", c.OutFile)
+	fmt.Fprintf(Console, "Table file: %s (%s)\n", c.TableFile, c.Format)
+	if c.Mode == MODEPARSE {
+		fmt.Fprintf(Console, "Splitted files to parse: %d\n", len(c.ChunkFiles))
+	} else {
+		fmt.Fprintf(Console, "Number of sequences to split: %d\n", c.SplitSize())
+	}
+
+	if c.Mode != MODEPARSE && c.Adaptive {
+		fmt.Fprintf(Console, "Sequences per request: adaptive, from %d (between %d and %d)\n", c.NumSeqs, c.MinNSeqs,
+			c.MaxNSeqs)
+	}
+
+	fmt.Fprintf(Console, "Number of threads: %d\n", c.NumThreads)
+
+	if c.Mode != MODEPARSE {
+		fmt.Fprintf(Console, "Server: %s\n", c.URL)
+		fmt.Fprintf(Console, "Timeout per request: %s\n", c.Timeout)
+		fmt.Fprintf(Console, "Concurrent requests: %d\n", c.NumRequests)
+		fmt.Fprintf(Console, "Max. times to send each request: %d\n", c.NumSend)
+		fmt.Fprintf(Console, "Delay before resending: %s (max. %s, jitter %v)\n", c.Backoff, c.MaxBackoff, c.Jitter)
+
+		if c.BisectTries > 0 {
+			fmt.Fprintf(Console, "Failed requests splitted in halves, sent up to %d times each\n", c.BisectTries)
+		}
+	}
+
+	fmt.Fprint(Console, "Algorithms: ")
 
 	if c.Algos & (SVM | ANN | RF | DA) == SVM | ANN | RF | DA {
-		fmt.Println("all (svm, ann, rf & da)")
+		fmt.Fprintln(Console, "all (svm, ann, rf & da)")
 
 	} else {
 		if c.Algos & SVM  == SVM{
-			fmt.Print("svm ")
+			fmt.Fprint(Console, "svm ")
 		}
 
 		if c.Algos & ANN == ANN {
-			fmt.Print("ann ")
+			fmt.Fprint(Console, "ann ")
 		}
 
 		if c.Algos & RF == RF {
-			fmt.Print("rf ")
+			fmt.Fprint(Console, "rf ")
 		}
 
 		if c.Algos & DA == DA {
-			fmt.Print("da ")
+			fmt.Fprint(Console, "da ")
 		}
 
-		fmt.Println("")
+		fmt.Fprintln(Console)
+	}
+
+	if len(c.MinProbs) > 0 {
+		fmt.Fprint(Console, "Minimum probabilities: ")
+
+		for _, algo := range ALGORITHMS {
+			if prob, ok := c.MinProbs[algo]; ok {
+				fmt.Fprintf(Console, "%s=%v ", strings.ToLower(AlgoName(algo)), prob)
+			}
+		}
+
+		fmt.Fprintln(Console)
+	}
+
+	fmt.Fprintf(Console, "Consensus: %s\n", c.Consensus)
+
+	fmt.Fprintf(Console, "Translation: %s\n", c.Translation)
+	fmt.Fprintf(Console, "Scanning: %s\n", c.Scanning)
+
+	if c.Mode != MODEPARSE {
+		fmt.Fprintf(Console, "Validation: %s\n", c.Validation)
 	}
 
-	fmt.Printf("Verbose: %v\n", c.Verbose)
-	fmt.Printf("Keep files: %v\n", c.Keep)
-	fmt.Printf("%s\n\n", "-----------------------------------------------------------------------")
+	fmt.Fprintf(Console, "Verbose: %v\n", c.Verbose)
+	fmt.Fprintf(Console, "Keep files: %v\n", c.Keep)
+	fmt.Fprintf(Console, "Compression of splitted files: %s\n", c.Compression)
+	fmt.Fprintf(Console, "Resume: %v\n", c.Resume)
+	fmt.Fprintf(Console, "Stream: %v\n", c.Stream)
+	fmt.Fprintf(Console, "%s\n\n", "-----------------------------------------------------------------------")
 
 }
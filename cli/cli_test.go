@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"bitbucket.org/germelcar/campred/util"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Run the test in an empty working directory
+func inTempDir(t *testing.T) string {
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.Chdir(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	return dir
+}
+
+// Write the files of a run as the splitting and the prediction do
+func writeRunFiles(t *testing.T, c *Cli) {
+
+	chunkFile := c.SplitPrefix() + "_1.fasta"
+
+	for _, fileName := range []string{c.CombinedFile(), chunkFile, c.RejectsName()} {
+
+		err := ioutil.WriteFile(fileName, []byte{}, 0644)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	state := util.NewJobState(c.StateFile(), c.RunSettings(), nil,
+		[]bio.FastaFile{{FileName: chunkFile, NumSeqs: 1}})
+
+	err := state.Save()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The rejects and the state files are kept after the run, even if the splitted files are in the
+// temporary directory
+func TestRunFiles(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		outFile		string
+		tableFile	string
+		prefix		string
+		tmp			bool
+		rejectsName	string
+	}{
+		{name: "output to stdout", outFile: bio.STDIO, tmp: true, rejectsName: "campred.rejects.tsv"},
+		{name: "table to stdout", tableFile: bio.STDIO, tmp: true, rejectsName: "campred.rejects.tsv"},
+		{name: "prefix given", outFile: bio.STDIO, prefix: "run", rejectsName: "run.rejects.tsv"},
+		{name: "output file", outFile: "out.fasta", tableFile: bio.STDIO, rejectsName: "out.fasta.rejects.tsv"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			inTempDir(t)
+			c := &Cli{Mode: MODEPREDICT, InFiles: []string{bio.STDIO}, OutFile: tt.outFile, TableFile: tt.tableFile,
+				Prefix: tt.prefix, Compression: bio.NOCOMPRESSION}
+
+			err := c.makeTmpDir()
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tmpDir := c.tmpDir
+
+			if (tmpDir != "") != tt.tmp {
+				t.Fatalf("Temporary directory %q, expected one: %v", tmpDir, tt.tmp)
+			}
+
+			if c.RejectsName() != tt.rejectsName {
+				t.Errorf("Rejects file %s, expected %s", c.RejectsName(), tt.rejectsName)
+			}
+
+			writeRunFiles(t, c)
+			c.Cleanup()
+
+			for _, fileName := range []string{c.RejectsName(), c.StateFile()} {
+				if _, err := os.Stat(fileName); err != nil {
+					t.Errorf("%s not kept after the run: %s", fileName, err)
+				}
+			}
+
+			if tmpDir == "" {
+				return
+			}
+
+			if _, err := os.Stat(tmpDir); !os.IsNotExist(err) {
+				t.Errorf("Temporary directory %s not removed", tmpDir)
+			}
+		})
+	}
+}
+
+// A failed run keeps its splitted files in the temporary directory, which is taken again by the
+// run that resumes it
+func TestResumeTmpDir(t *testing.T) {
+
+	inTempDir(t)
+	c := &Cli{Mode: MODEPREDICT, InFiles: []string{bio.STDIO}, OutFile: bio.STDIO, Compression: bio.NOCOMPRESSION}
+	err := c.makeTmpDir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer c.Cleanup()
+	writeRunFiles(t, c)
+	c.CleanupFailed()
+
+	if _, err := os.Stat(c.SplitPrefix() + "_1.fasta"); err != nil {
+		t.Fatalf("Splitted file not kept after a failed run: %s", err)
+	}
+
+	if _, err := os.Stat(c.CombinedFile()); !os.IsNotExist(err) {
+		t.Errorf("Copy of the input %s not removed after a failed run", c.CombinedFile())
+	}
+
+	resumed := &Cli{Mode: MODEPREDICT, InFiles: []string{bio.STDIO}, OutFile: bio.STDIO,
+		Compression: bio.NOCOMPRESSION, Resume: true}
+	err = resumed.makeTmpDir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.tmpDir != c.tmpDir {
+		os.RemoveAll(resumed.tmpDir)
+		t.Fatalf("Resumed in %s, expected %s", resumed.tmpDir, c.tmpDir)
+	}
+
+	if filepath.Dir(resumed.SplitPrefix()) != c.tmpDir {
+		t.Errorf("Splitted files of the resumed run in %s, expected %s", filepath.Dir(resumed.SplitPrefix()), c.tmpDir)
+	}
+}
@@ -0,0 +1,103 @@
+package cli
+
+import (
+	flag "github.com/spf13/pflag"
+	"encoding/json"
+	"io/ioutil"
+	"errors"
+	"fmt"
+	"strconv"
+	"bytes"
+)
+
+// Read a configuration file (JSON) whose keys are the long names of the flags, e.g.:
+//
+//	{
+//		"url": "http://mirror.example.org/predict/hii.php",
+//		"timeout": "5m",
+//		"requests": 4,
+//		"send": 20,
+//		"input": ["s1.fasta", "s2.fasta"]
+//	}
+//
+// The flags given in the command line have priority over the values of the file
+func loadConfig(fileName string) error {
+
+	buff, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return err
+	}
+
+	// The numbers are kept as written, so big integers do not lose digits as float64
+	values := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(buff))
+	dec.UseNumber()
+	err = dec.Decode(&values)
+
+	if err == nil && dec.More() {
+		err = errors.New("data after the configuration")
+	}
+
+	if err != nil {
+		return errors.New(fmt.Sprintf("Invalid configuration file %s: %s", fileName, err))
+	}
+
+	for name, value := range values {
+
+		f := flag.Lookup(name)
+
+		if f == nil || name == "config" {
+			return errors.New(fmt.Sprintf("Unknown option in configuration file %s: %s", fileName, name))
+		}
+
+		if f.Changed {
+			continue
+		}
+
+		// A list (e.g. "input") sets the flag once per value
+		list, ok := value.([]interface{})
+
+		if !ok {
+			list = []interface{}{value}
+		}
+
+		for _, v := range list {
+
+			err = flag.Set(name, configValue(v))
+
+			if err != nil {
+				break
+			}
+		}
+
+		if err != nil {
+			return errors.New(fmt.Sprintf("Invalid value for %s in configuration file %s: %s", name, fileName, err))
+		}
+	}
+
+	return nil
+}
+
+// Value of the configuration file as given in the command line. The numbers in exponent notation
+// (e.g. 1e6) are written in full, as the integer flags do not parse them
+func configValue(v interface{}) string {
+
+	num, ok := v.(json.Number)
+
+	if !ok {
+		return fmt.Sprint(v)
+	}
+
+	if _, err := num.Int64(); err == nil {
+		return num.String()
+	}
+
+	f, err := num.Float64()
+
+	if err != nil {
+		return num.String()
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	flag "github.com/spf13/pflag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The numbers of the configuration file are given to the flags as written, not as float64
+func TestLoadConfig(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		config		string
+		flag		string
+		expected	string		// Value of the flag
+		err			string		// Part of the error expected ("" if none)
+	}{
+		{name: "integer", config: `{"requests": 4}`, flag: "requests", expected: "4"},
+		{name: "exponent", config: `{"max-nseqs": 1e6}`, flag: "max-nseqs", expected: "1000000"},
+		{name: "big integer", config: `{"mock-seed": 12345678901234567}`, flag: "mock-seed",
+			expected: "12345678901234567"},
+		{name: "float", config: `{"min-score": 0.75}`, flag: "min-score", expected: "0.75"},
+		{name: "string", config: `{"timeout": "5m"}`, flag: "timeout", expected: "5m0s"},
+		{name: "fraction for an integer", config: `{"requests": 2.5}`, flag: "requests",
+			err: "Invalid value for requests"},
+		{name: "unknown option", config: `{"colour": 1}`, err: "Unknown option"},
+		{name: "data after the configuration", config: `{"requests": 4} {}`, err: "Invalid configuration file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if f := flag.Lookup(tt.flag); f != nil {
+
+				defValue := f.DefValue
+
+				t.Cleanup(func() {
+					flag.Set(tt.flag, defValue)
+					f.Changed = false
+				})
+			}
+
+			fileName := filepath.Join(t.TempDir(), "campred.json")
+			err := ioutil.WriteFile(fileName, []byte(tt.config), 0644)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = loadConfig(fileName)
+
+			if tt.err != "" {
+
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Error %v, expected one with %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if value := flag.Lookup(tt.flag).Value.String(); value != tt.expected {
+				t.Errorf("%s is %s, expected %s", tt.flag, value, tt.expected)
+			}
+		})
+	}
+}
@@ -1,8 +1,10 @@
 package common
 
 import (
+	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -11,6 +13,9 @@ var (
 	StatusLog 	*log.Logger
 	WarningLog	*log.Logger
 	ErrorLog  	*log.Logger
+
+	// Where the messages (configuration, progress...) are printed
+	Console		io.Writer = os.Stdout
 )
 
 const (
@@ -19,13 +24,17 @@ const (
 	RF
 	DA
 
+	// Defaults of the server options (see the flags and the configuration file)
 	CAMPREDURL      = "http://www.camp.bicnirrh.res.in/predict/hii.php"
 	REQUESTTIMEOUT  = time.Duration(time.Minute * 10) // Timeout of 10 minutes per request
 	MAXNUMTRIESSEND = 10                              // Maximum number of tries per request
-	MAXREQUESTS     = 2                              // Maximum number of request concurrently
+	MAXREQUESTS     = 2                               // Maximum number of request concurrently
 	VERSION         = "0.1"
 )
 
+// Order in which the algorithms are reported (tables, summaries, etc.)
+var ALGORITHMS = []uint8{SVM, RF, DA, ANN}
+
 
 func init() {
 
@@ -38,6 +47,16 @@ func init() {
 	ErrorLog = log.New(os.Stderr, "[ERROR] ", log.Ldate|log.Ltime|log.Lshortfile)
 }
 
+// Print the messages and logs to the standard error, so the standard output only has the results
+// (e.g. "-o -" in a pipeline)
+func MessagesToStderr() {
+
+	Console = os.Stderr
+	InfoLog.SetOutput(os.Stderr)
+	StatusLog.SetOutput(os.Stderr)
+	WarningLog.SetOutput(os.Stderr)
+}
+
 
 func NumAlgos(algos uint8) int {
 	tot := 0
@@ -60,3 +79,46 @@ func NumAlgos(algos uint8) int {
 
 	return tot
 }
+
+func AlgoName(algo uint8) string {
+
+	switch algo {
+
+	case SVM:
+		return "SVM"
+
+	case ANN:
+		return "ANN"
+
+	case RF:
+		return "RF"
+
+	case DA:
+		return "DA"
+
+	}
+
+	return ""
+}
+
+// The algorithm flag for a name given by the user (svm, ann, rf or da)
+func AlgoByName(name string) (uint8, bool) {
+
+	switch strings.ToLower(name) {
+
+	case "svm":
+		return SVM, true
+
+	case "ann":
+		return ANN, true
+
+	case "rf":
+		return RF, true
+
+	case "da":
+		return DA, true
+
+	}
+
+	return 0, false
+}
@@ -0,0 +1,456 @@
+package mock
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	. "bitbucket.org/germelcar/campred/common"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"errors"
+	"fmt"
+)
+
+// How the stand-in decides the class and the probability of each sequence
+const (
+	RULERANDOM	= "random"	// Pseudo random, but always the same for a sequence, an algorithm and a seed
+	RULECHARGE	= "charge"	// By the net charge and the hydrophobic residues of the sequence, as most AMPs are cationic
+)
+
+// How a request can fail (see Server.FailRate)
+const (
+	FAILEMPTY		= "empty"		// The page has the headers of the results, but the tables have no rows
+	FAILERROR		= "500"			// Internal server error
+	FAILBUSY		= "503"			// Service unavailable, with a Retry-After of one second
+	FAILSLOW		= "slow"		// The answer is sent after Server.Delay (e.g. to hit the timeout of the requests)
+	FAILTRUNCATED	= "truncated"	// Only the first half of the page is sent
+	FAILPARTIAL		= "partial"		// The tables only have the rows of the first half of the sequences
+)
+
+var FAILURES = []string{FAILEMPTY, FAILERROR, FAILBUSY, FAILSLOW, FAILTRUNCATED, FAILPARTIAL}
+
+const (
+	DEFAULTADDR		= "127.0.0.1:8080"
+	DEFAULTDELAY	= time.Second * 30
+)
+
+// Titles of the results of each algorithm in the pages of CAMP
+var algoTitles = map[string]string{
+	"svm": "Results with Support Vector Machine (SVM) classifier",
+	"rf": "Results with Random Forest classifier",
+	"ann": "Results with Artificial Neural Network (ANN) classifier",
+	"da": "Results with Discriminant Analysis classifier",
+}
+
+const formPage = `<html><head><title>CAMP: Prediction</title></head><body>
+<form action="" method="post" enctype="multipart/form-data">
+<p>Sequences (FASTA): <input type="file" name="userfile"></p>
+<p>
+<input type="checkbox" name="algo[]" value="svm"> Support Vector Machine
+<input type="checkbox" name="algo[]" value="rf"> Random Forest
+<input type="checkbox" name="algo[]" value="ann"> Artificial Neural Network
+<input type="checkbox" name="algo[]" value="da"> Discriminant Analysis
+</p>
+<input type="submit" value="Submit">
+</form></body></html>
+`
+
+// Page of CAMP with an error message instead of results
+const messagePage = `<html><head><title>CAMP: Results</title></head><body>
+<table class="corner"><tr><td>
+<p><font color="red">%s</font></p>
+<p><a href="javascript:history.back()">Back</a></p>
+</td></tr></table>
+</body></html>
+`
+
+// Local stand-in of the CAMP server: it serves the same form (POST with the file "userfile" and the
+// algorithms "algo[]") and answers with pages like the ones of CAMP, so the requests, the retries,
+// the parsing and the consensus can be tried without network
+type Server struct {
+	Addr		string
+	Rule		string
+	Seed		int64
+	FailRate	float64		// Fraction (0 to 1) of the requests that fail
+	Failures	[]string	// How they fail, one of them at random
+	Delay		time.Duration
+	SeqDelay	time.Duration	// Time of every answer per sequence, so the bigger requests take longer
+	MaxSeqs		int			// Requests with more sequences are rejected. 0 for no limit
+	Poison		string		// Requests with a sequence that has it always fail (500), as if CAMP choked on it
+	Verbose		bool
+
+	rnd			*rand.Rand
+	numReqs		int
+	mu			sync.Mutex
+}
+
+func NewServer(addr, rule string, seed int64, failRate float64, failures []string, delay time.Duration,
+	verbose bool) *Server {
+
+	return &Server{
+		Addr: addr,
+		Rule: rule,
+		Seed: seed,
+		FailRate: failRate,
+		Failures: failures,
+		Delay: delay,
+		Verbose: verbose,
+		rnd: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Check the rule, the failures and their rate
+func (s *Server) Check() error {
+
+	if s.Rule != RULERANDOM && s.Rule != RULECHARGE {
+		return errors.New(fmt.Sprintf("Unrecognized mock rule: %s (valid: %s, %s)", s.Rule, RULERANDOM, RULECHARGE))
+	}
+
+	if s.FailRate < 0 || s.FailRate > 1 {
+		return errors.New(fmt.Sprintf("Invalid failure rate: %v (must be between 0 and 1)", s.FailRate))
+	}
+
+	for _, f := range s.Failures {
+
+		valid := false
+
+		for _, known := range FAILURES {
+			if f == known {
+				valid = true
+			}
+		}
+
+		if !valid {
+			return errors.New(fmt.Sprintf("Unrecognized failure: %s (valid: %s)", f, strings.Join(FAILURES, ", ")))
+		}
+	}
+
+	if s.FailRate > 0 && len(s.Failures) == 0 {
+		return errors.New("A failure rate was given, but no failures")
+	}
+
+	return nil
+}
+
+// Listen and serve until the process is stopped
+func (s *Server) ListenAndServe() error {
+
+	InfoLog.Printf("Mock CAMP server listening on http://%s/ (rule: %s, seed: %d, failure rate: %v %v)",
+		s.Addr, s.Rule, s.Seed, s.FailRate, s.Failures)
+
+	return http.ListenAndServe(s.Addr, s)
+}
+
+// Number of the request and how it fails ("" if it does not)
+func (s *Server) nextRequest() (int, string) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.numReqs++
+
+	if s.FailRate > 0 && s.rnd.Float64() < s.FailRate {
+		return s.numReqs, s.Failures[s.rnd.Intn(len(s.Failures))]
+	}
+
+	return s.numReqs, ""
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "POST" {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, formPage)
+		return
+	}
+
+	numReq, failure := s.nextRequest()
+	seqs, algos, err := readForm(r)
+
+	if err != nil {
+
+		if s.Verbose {
+			WarningLog.Printf("Request %d: %s", numReq, err)
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// As CAMP, a request with too many sequences or with invalid sequences is answered with a message
+	if msg := s.rejection(seqs); msg != "" {
+
+		if s.Verbose {
+			InfoLog.Printf("Request %d: %d sequences. Rejected (%s)", numReq, len(seqs), msg)
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, messagePage, msg)
+		return
+	}
+
+	if s.poisoned(seqs) {
+
+		if s.Verbose {
+			InfoLog.Printf("Request %d: %d sequences. Failing (sequence with %s)", numReq, len(seqs), s.Poison)
+		}
+
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if s.Verbose && failure != "" {
+		InfoLog.Printf("Request %d: %d sequences, algorithms %v. Failing (%s)", numReq, len(seqs), algos, failure)
+
+	} else if s.Verbose {
+		InfoLog.Printf("Request %d: %d sequences, algorithms %v", numReq, len(seqs), algos)
+	}
+
+	switch failure {
+
+	case FAILERROR:
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+
+	case FAILBUSY:
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+
+	}
+
+	// The answer takes longer with more sequences. The client gives up on it when its request times out
+	delay := s.SeqDelay * time.Duration(len(seqs))
+
+	if failure == FAILSLOW {
+		delay += s.Delay
+	}
+
+	if !wait(r, delay) {
+
+		if s.Verbose {
+			InfoLog.Printf("Request %d: %s", numReq, r.Context().Err())
+		}
+
+		return
+	}
+
+	numRows := len(seqs)
+
+	switch failure {
+
+	case FAILEMPTY:
+		numRows = 0
+
+	case FAILPARTIAL:
+		numRows = len(seqs) / 2
+
+	}
+
+	page := s.resultsPage(seqs, algos, numRows)
+
+	if failure == FAILTRUNCATED {
+		page = page[ : len(page) / 2]
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	io.WriteString(w, page)
+}
+
+// Wait for the delay, unless the client gives up on the request before. Returns whether the request is
+// still wanted
+func wait(r *http.Request, delay time.Duration) bool {
+
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+
+	case <-timer.C:
+		return true
+
+	case <-r.Context().Done():
+		return false
+
+	}
+}
+
+// Message of CAMP rejecting the sequences ("" if they are accepted)
+func (s *Server) rejection(seqs []bio.FastaSeq) string {
+
+	if s.MaxSeqs > 0 && len(seqs) > s.MaxSeqs {
+		return fmt.Sprintf("Sequence limit exceeded. Please submit at most %d sequences per request", s.MaxSeqs)
+	}
+
+	for i, fs := range seqs {
+
+		if fs.Seq == "" {
+			return fmt.Sprintf("Invalid sequence found: %d. The sequence is empty", i + 1)
+		}
+
+		for _, r := range fs.Seq {
+			if !strings.ContainsRune(bio.AMINOACIDS, r) {
+				return fmt.Sprintf("Invalid sequence found: %d. Only the 20 standard amino acids are allowed", i + 1)
+			}
+		}
+	}
+
+	return ""
+}
+
+// Whether a sequence has the poison
+func (s *Server) poisoned(seqs []bio.FastaSeq) bool {
+
+	if s.Poison == "" {
+		return false
+	}
+
+	for _, fs := range seqs {
+		if strings.Contains(strings.ToUpper(fs.Seq), s.Poison) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sequences and algorithms of the form
+func readForm(r *http.Request) ([]bio.FastaSeq, []string, error) {
+
+	file, _, err := r.FormFile("userfile")
+
+	if err != nil {
+		return nil, nil, errors.New(fmt.Sprintf("No sequences (userfile): %s", err))
+	}
+
+	defer file.Close()
+	seqs := []bio.FastaSeq{}
+	rdr := bio.NewReader(file)
+
+	for {
+
+		fs, err := rdr.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("Invalid sequences: %s", err))
+		}
+
+		seqs = append(seqs, fs)
+	}
+
+	algos := r.MultipartForm.Value["algo[]"]
+
+	if len(algos) == 0 {
+		return nil, nil, errors.New("No algorithms (algo[])")
+	}
+
+	for _, a := range algos {
+		if _, ok := algoTitles[a]; !ok {
+			return nil, nil, errors.New(fmt.Sprintf("Unknown algorithm: %s", a))
+		}
+	}
+
+	return seqs, algos, nil
+}
+
+// Page with a table per algorithm, one row for each of the first "numRows" sequences (numbered from 1,
+// as CAMP does). The ANN gives no probability
+func (s *Server) resultsPage(seqs []bio.FastaSeq, algos []string, numRows int) string {
+
+	var b strings.Builder
+
+	b.WriteString("<html><head><title>CAMP: Results</title></head><body>\n")
+	b.WriteString("<table class=\"corner\"><tr><td>\n")
+
+	for _, a := range algos {
+
+		fmt.Fprintf(&b, "<p><strong>%s</strong></p>\n<table>\n", algoTitles[a])
+
+		if a == "ann" {
+			b.WriteString("<tr><th>Seq. ID.</th><th>Class</th></tr>\n")
+		} else {
+			b.WriteString("<tr><th>Seq. ID.</th><th>Class</th><th>AMP Probability</th></tr>\n")
+		}
+
+		for i, fs := range seqs[ : numRows] {
+
+			prob := s.probability(fs.Seq, a)
+			class := "NAMP"
+
+			if prob >= 0.5 {
+				class = "AMP"
+			}
+
+			if a == "ann" {
+				fmt.Fprintf(&b, "<tr><td> %d </td><td> %s </td></tr>\n", i + 1, class)
+			} else {
+				fmt.Fprintf(&b, "<tr><td> %d </td><td> %s </td><td> %.3f </td></tr>\n", i + 1, class, prob)
+			}
+		}
+
+		b.WriteString("</table>\n")
+	}
+
+	b.WriteString("</td></tr></table>\n</body></html>\n")
+
+	return b.String()
+}
+
+// Probability of the sequence being an AMP by the algorithm. It only depends on the sequence (and the seed),
+// so a sequence has the same results whatever the request it is sent in
+func (s *Server) probability(seq, algo string) float64 {
+
+	if s.Rule == RULECHARGE {
+		return chargeProbability(seq)
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%s:%s", s.Seed, algo, seq)
+
+	return float64(h.Sum64() % 1000) / 1000
+}
+
+// Net charge (K and R positive, D and E negative) and fraction of hydrophobic residues. A cationic and
+// amphipathic peptide (charge of +2 or more, 30% or more hydrophobic residues) has a probability over 0.5
+func chargeProbability(seq string) float64 {
+
+	if seq == "" {
+		return 0
+	}
+
+	charge := 0
+	hydrophobic := 0
+
+	for _, r := range strings.ToUpper(seq) {
+
+		switch r {
+
+		case 'K', 'R':
+			charge++
+
+		case 'D', 'E':
+			charge--
+
+		case 'A', 'I', 'L', 'M', 'F', 'V', 'W':
+			hydrophobic++
+
+		}
+	}
+
+	frac := float64(hydrophobic) / float64(len(seq))
+	score := math.Min(float64(charge) - 2, frac * 10 - 3) + 0.5
+
+	return 1 / (1 + math.Exp(-score))
+}
@@ -0,0 +1,105 @@
+package report
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+)
+
+// Write the AMP regions of the sequences of the parent file (the file that was scanned): the overlapping
+// windows predicted as AMP ("preds", by their number in the file of windows) merged, one row per region.
+// With several samples (of the parent file), the first column is the sample of the parent.
+// Returns the number of regions written
+func WriteRegions(parentFile, outFile, format string, s bio.Scanning, preds map[int]string,
+	samples []bio.Sample) (int, error) {
+
+	if !ValidFormat(format) {
+		return 0, errors.New(fmt.Sprintf("%s: %s", "Unknown table format", format))
+	}
+
+	fout, err := bio.CreateFile(outFile)
+
+	if err != nil {
+		return 0, err
+	}
+
+	wrt := csv.NewWriter(fout)
+
+	if format == TSV {
+		wrt.Comma = '\t'
+	}
+
+	header := []string{"Parent", "Start", "End", "Length", "Windows", "Sequence"}
+	withSample := len(samples) > 1
+
+	if withSample {
+		header = append([]string{"Sample"}, header...)
+	}
+
+	err = wrt.Write(header)
+
+	if err != nil {
+		fout.Close()
+		return 0, err
+	}
+
+	// The windows are numbered in the order they were written, parent by parent
+	numWindow := 0
+	totRegions := 0
+
+	err = bio.EachSeq(parentFile, func(numSeq int, fs bio.FastaSeq) error {
+
+		positives := []bio.Window{}
+
+		for _, w := range s.Windows(fs) {
+
+			numWindow++
+			id, ok := preds[numWindow]
+
+			if !ok {
+				continue
+			}
+
+			if id != w.ID {
+				return errors.New(fmt.Sprintf("Window %d is %s, but the results are for %s", numWindow, w.ID, id))
+			}
+
+			positives = append(positives, w)
+		}
+
+		for _, r := range bio.MergeWindows(fs, positives) {
+
+			row := []string{r.Parent, fmt.Sprint(r.Start), fmt.Sprint(r.End), fmt.Sprint(r.End - r.Start + 1),
+				fmt.Sprint(r.NumWindows), r.Seq}
+
+			if withSample {
+				row = append([]string{sampleName(samples, numSeq)}, row...)
+			}
+
+			err := wrt.Write(row)
+
+			if err != nil {
+				return err
+			}
+
+			totRegions++
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		fout.Close()
+		return 0, errors.New(fmt.Sprintf("Error while writting regions %s: %s", outFile, err))
+	}
+
+	wrt.Flush()
+
+	if wrt.Error() != nil {
+		fout.Close()
+		return 0, wrt.Error()
+	}
+
+	return totRegions, fout.Close()
+}
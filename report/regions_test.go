@@ -0,0 +1,71 @@
+package report
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteRegions(t *testing.T) {
+
+	dir := t.TempDir()
+	parentFile := filepath.Join(dir, "parents.fasta")
+	err := ioutil.WriteFile(parentFile, []byte(">p1\nACDEFGHIKLMNPQRSTVWY\n>p2\nACDEF\n"), 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Windows 1 to 4 of p1 (1-8, 5-12, 9-16 and 13-20) and window 5 of p2 (1-5, shorter than the window)
+	scanning := bio.Scanning{Window: 8, Step: 4}
+	header := "Parent\tStart\tEnd\tLength\tWindows\tSequence\n"
+
+	tests := []struct {
+		name		string
+		preds		map[int]string
+		expected	string		// Rows of the regions
+		err			string		// Part of the error expected ("" if none)
+	}{
+		{name: "no positive windows", preds: map[int]string{}},
+		{name: "overlapping windows", preds: map[int]string{1: "p1_w1-8", 2: "p1_w5-12"},
+			expected: "p1\t1\t12\t12\t2\tACDEFGHIKLMN\n"},
+		{name: "adjacent windows", preds: map[int]string{1: "p1_w1-8", 3: "p1_w9-16"},
+			expected: "p1\t1\t8\t8\t1\tACDEFGHI\np1\t9\t16\t8\t1\tKLMNPQRS\n"},
+		{name: "sequence shorter than the window", preds: map[int]string{4: "p1_w13-20", 5: "p2_w1-5"},
+			expected: "p1\t13\t20\t8\t1\tPQRSTVWY\np2\t1\t5\t5\t1\tACDEF\n"},
+		{name: "results of another window", preds: map[int]string{2: "p1_w1-8"}, err: "Window 2 is p1_w5-12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			outFile := filepath.Join(dir, strings.Replace(tt.name, " ", "_", -1) + ".tsv")
+			_, err := WriteRegions(parentFile, outFile, TSV, scanning, tt.preds, nil)
+
+			if tt.err != "" {
+
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("Error %v, expected one with %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buff, err := ioutil.ReadFile(outFile)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(buff) != header + tt.expected {
+				t.Errorf("Regions:\n%s\nexpected:\n%s", buff, header + tt.expected)
+			}
+		})
+	}
+}
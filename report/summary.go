@@ -0,0 +1,83 @@
+package report
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"bitbucket.org/germelcar/campred/util"
+	"encoding/csv"
+	"errors"
+	"fmt"
+)
+
+// Name of the sample of the sequence (NA if it has none)
+func sampleName(samples []bio.Sample, numSeq int) string {
+
+	if sample := bio.SampleOf(samples, numSeq); sample != nil {
+		return sample.Name
+	}
+
+	return NA
+}
+
+// Write a table (CSV or TSV) with one row per sample: its file, its number of sequences, how many of them
+// were evaluated, excluded, not evaluated or failed (see WriteTable) and how many were predicted as AMP
+func WriteSummary(outFile, format string, samples []bio.Sample, results util.Results, rejects []bio.Reject,
+	preds map[int]string) error {
+
+	if !ValidFormat(format) {
+		return errors.New(fmt.Sprintf("%s: %s", "Unknown table format", format))
+	}
+
+	fout, err := bio.CreateFile(outFile)
+
+	if err != nil {
+		return err
+	}
+
+	wrt := csv.NewWriter(fout)
+
+	if format == TSV {
+		wrt.Comma = '\t'
+	}
+
+	err = wrt.Write([]string{"Sample", "File", "Sequences", "Evaluated", "Excluded", "NotEvaluated", "Failed", "AMP"})
+
+	if err != nil {
+		fout.Close()
+		return err
+	}
+
+	rejByNum := rejectsByNum(rejects)
+
+	for _, s := range samples {
+
+		counts := make(map[string]int)
+		totAMP := 0
+
+		for numSeq := s.First; numSeq <= s.Last; numSeq++ {
+
+			counts[seqStatus(results[numSeq], unsentReject(numSeq, rejByNum))]++
+
+			if _, ok := preds[numSeq]; ok {
+				totAMP++
+			}
+		}
+
+		err = wrt.Write([]string{s.Name, s.FileName, fmt.Sprint(s.NumSeqs()), fmt.Sprint(counts[EVALUATED]),
+			fmt.Sprint(counts[EXCLUDED]), fmt.Sprint(counts[NOTEVALUATED]), fmt.Sprint(counts[FAILED]),
+			fmt.Sprint(totAMP)})
+
+		if err != nil {
+			fout.Close()
+			return errors.New(fmt.Sprintf("Error while writting summary %s: %s", outFile, err))
+		}
+	}
+
+	wrt.Flush()
+
+	if wrt.Error() != nil {
+		fout.Close()
+		return wrt.Error()
+	}
+
+	return fout.Close()
+}
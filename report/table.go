@@ -0,0 +1,185 @@
+package report
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"bitbucket.org/germelcar/campred/util"
+	. "bitbucket.org/germelcar/campred/common"
+	"encoding/csv"
+	"errors"
+	"fmt"
+)
+
+const (
+	CSV = "csv"
+	TSV = "tsv"
+
+	NA  = "NA" // Value for the missing classes or probabilities
+
+	// Status of the sequences
+	EVALUATED		= "evaluated"
+	NOTEVALUATED	= "not-evaluated"	// Length out of the range accepted
+	EXCLUDED		= "excluded"		// Not sent by the validation (see the rejects file)
+	FAILED			= "failed"			// Its request (or response) has failed
+)
+
+func ValidFormat(format string) bool {
+	return format == CSV || format == TSV
+}
+
+func tableHeader(algos uint8, withSample bool) []string {
+
+	header := []string{"ID", "Description", "Length", "Status"}
+
+	if withSample {
+		header = append([]string{"Sample"}, header...)
+	}
+
+	for _, algo := range ALGORITHMS {
+		if algos & algo == algo {
+			header = append(header, AlgoName(algo) + "_Class", AlgoName(algo) + "_Prob")
+		}
+	}
+
+	return header
+}
+
+// Whether the sequence was evaluated or why not
+func seqStatus(sr *util.SeqResult, rej *bio.Reject) string {
+
+	if sr != nil {
+		return EVALUATED
+	}
+
+	if rej == nil {
+		return FAILED
+	}
+
+	if rej.Action == bio.NOTEVALUATED {
+		return NOTEVALUATED
+	}
+
+	return EXCLUDED
+}
+
+func tableRow(fs bio.FastaSeq, algos uint8, sr *util.SeqResult, rej *bio.Reject) []string {
+
+	row := []string{fs.ID, fs.Desc, fmt.Sprint(fs.Len()), seqStatus(sr, rej)}
+
+	for _, algo := range ALGORITHMS {
+
+		if algos & algo != algo {
+			continue
+		}
+
+		// The sequence could be missing if its file has failed to be processed
+		var ar util.AlgoResult
+		var ok bool
+
+		if sr != nil {
+			ar, ok = sr.Algos[algo]
+		}
+
+		if !ok {
+			row = append(row, NA, NA)
+			continue
+		}
+
+		if ar.HasProb {
+			row = append(row, ar.Class, fmt.Sprint(ar.Prob))
+		} else {
+			row = append(row, ar.Class, NA)
+		}
+	}
+
+	return row
+}
+
+// Reject of the sequence if it was not sent. Stripped sequences were sent, so they have results
+func unsentReject(numSeq int, rejByNum map[int]*bio.Reject) *bio.Reject {
+
+	rej := rejByNum[numSeq]
+
+	if rej != nil && rej.Action == bio.POLICYSTRIP {
+		return nil
+	}
+
+	return rej
+}
+
+func rejectsByNum(rejects []bio.Reject) map[int]*bio.Reject {
+
+	rejByNum := make(map[int]*bio.Reject)
+
+	for i := range rejects {
+		rejByNum[rejects[i].Num] = &rejects[i]
+	}
+
+	return rejByNum
+}
+
+// Write a table (CSV or TSV) with one row per sequence of the input file: its ID, its length, whether
+// it was evaluated, and the class and probability given by each algorithm. The sequences with issues
+// found by the validation ("rejects") are reported as excluded or not evaluated. With several samples,
+// the first column is the sample of the sequence
+func WriteTable(inFile, outFile, format string, algos uint8, results util.Results, rejects []bio.Reject,
+	samples []bio.Sample) error {
+
+	if !ValidFormat(format) {
+		return errors.New(fmt.Sprintf("%s: %s", "Unknown table format", format))
+	}
+
+	fout, err := bio.CreateFile(outFile)
+
+	if err != nil {
+		return err
+	}
+
+	wrt := csv.NewWriter(fout)
+
+	if format == TSV {
+		wrt.Comma = '\t'
+	}
+
+	withSample := len(samples) > 1
+	err = wrt.Write(tableHeader(algos, withSample))
+
+	if err != nil {
+		fout.Close()
+		return err
+	}
+
+	rejByNum := rejectsByNum(rejects)
+
+	err = bio.EachSeq(inFile, func(numSeq int, fs bio.FastaSeq) error {
+
+		sr := results[numSeq]
+
+		// The results are for another sequence, e.g. the input file has changed since it was sent
+		if sr != nil && sr.ID != fs.ID {
+			return errors.New(fmt.Sprintf("Sequence %d is %s, but the results are for %s", numSeq, fs.ID, sr.ID))
+		}
+
+		row := tableRow(fs, algos, sr, unsentReject(numSeq, rejByNum))
+
+		if withSample {
+			row = append([]string{sampleName(samples, numSeq)}, row...)
+		}
+
+		return wrt.Write(row)
+	})
+
+	if err != nil {
+		fout.Close()
+		return errors.New(fmt.Sprintf("Error while writting table %s: %s", outFile, err))
+	}
+
+	wrt.Flush()
+
+	if wrt.Error() != nil {
+		fout.Close()
+		return wrt.Error()
+	}
+
+	// Closing a compressed file writes its last block
+	return fout.Close()
+}
@@ -0,0 +1,233 @@
+package util
+
+import (
+	. "bitbucket.org/germelcar/campred/common"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"errors"
+	"fmt"
+)
+
+const (
+	DEFAULTMINCHUNK	= 1
+	DEFAULTMAXCHUNK	= 200
+
+	SHRINKFACTOR	= 0.5	// Size after a request that has timed out or come back incomplete
+	GROWFACTOR		= 1.5	// Size after a fast and clean request
+	FASTFRACTION	= 0.5	// A request is fast if a grown one would take less than this fraction of the timeout
+)
+
+// Requests of a size sent by the sizer and how they went
+type sizeStats struct {
+	NumReqs		int
+	NumFailed	int
+	NumSeqs		int				// Sequences of the clean requests
+	Elapsed		time.Duration	// Time of the clean requests
+}
+
+// Number of sequences per request tuned by the behaviour of the server: the size is halved when
+// a request times out, comes back incomplete or is rejected for having too many sequences, and grown
+// by a half when the responses are fast enough for a bigger request to stay far from the timeout.
+// It is always between Min and Max, and below the smallest size rejected for having too many sequences.
+// The requests are sent concurrently, so the sizer is shared by all of them
+type ChunkSizer struct {
+	Min			int
+	Max			int
+	Timeout		time.Duration
+
+	size		int
+	limit		int		// Smallest size rejected for having too many sequences (0 if none)
+	stats		map[int]*sizeStats
+	mu			sync.Mutex
+}
+
+func NewChunkSizer(start, min, max int, timeout time.Duration) *ChunkSizer {
+
+	cs := &ChunkSizer{
+		Min: min,
+		Max: max,
+		Timeout: timeout,
+		stats: make(map[int]*sizeStats),
+	}
+
+	cs.size = cs.bound(start)
+
+	return cs
+}
+
+func (cs *ChunkSizer) bound(size int) int {
+
+	if size < cs.Min {
+		return cs.Min
+	}
+
+	if size > cs.Max {
+		size = cs.Max
+	}
+
+	if cs.limit > 0 && size >= cs.limit && cs.limit > cs.Min {
+		size = cs.limit - 1
+	}
+
+	return size
+}
+
+// Number of sequences of the next request
+func (cs *ChunkSizer) Size() int {
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.size
+}
+
+// Record how a request of "numSeqs" sequences went, and tune the size by it
+func (cs *ChunkSizer) Observe(numSeqs int, elapsed time.Duration, err error) {
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	st, ok := cs.stats[numSeqs]
+
+	if !ok {
+		st = &sizeStats{}
+		cs.stats[numSeqs] = st
+	}
+
+	st.NumReqs++
+
+	if err != nil {
+
+		st.NumFailed++
+
+		if tooManySeqs(err) && (cs.limit == 0 || numSeqs < cs.limit) {
+			cs.limit = numSeqs
+		}
+
+		// The requests are concurrent, so a request sent before the last change is shrunk from its own size
+		if tooBig(err) && numSeqs > cs.Min {
+			cs.resize(int(float64(numSeqs) * SHRINKFACTOR), err.Error())
+		}
+
+		return
+	}
+
+	st.NumSeqs += numSeqs
+	st.Elapsed += elapsed
+
+	// The time of a request grows with its sequences, so the time of a bigger one is estimated from
+	// the time per sequence of this one
+	grown := int(float64(cs.size) * GROWFACTOR)
+
+	if grown == cs.size {
+		grown++
+	}
+
+	perSeq := elapsed / time.Duration(numSeqs)
+
+	if cs.size < cs.bound(grown) && perSeq * time.Duration(grown) < time.Duration(float64(cs.Timeout) * FASTFRACTION) {
+		cs.resize(grown, fmt.Sprintf("fast response (%s for %d sequences)", elapsed.Round(time.Millisecond), numSeqs))
+	}
+}
+
+func (cs *ChunkSizer) resize(size int, reason string) {
+
+	size = cs.bound(size)
+
+	if size == cs.size {
+		return
+	}
+
+	InfoLog.Printf("Requests of %d sequences from now (were %d): %s", size, cs.size, reason)
+	cs.size = size
+}
+
+// Log the requests sent with each size and their throughput (sequences per minute of the clean requests)
+func (cs *ChunkSizer) Report() {
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	sizes := []int{}
+
+	for size := range cs.stats {
+		sizes = append(sizes, size)
+	}
+
+	sort.Ints(sizes)
+	InfoLog.Printf("Sizes of the requests (adaptive, between %d and %d sequences). Last size: %d",
+		cs.Min, cs.Max, cs.size)
+
+	if cs.limit > 0 {
+		InfoLog.Printf("Requests of %d or more sequences rejected by the server", cs.limit)
+	}
+
+	for _, size := range sizes {
+
+		st := cs.stats[size]
+
+		if st.NumSeqs == 0 {
+			InfoLog.Printf("    %d sequences: %d requests (%d failed)", size, st.NumReqs, st.NumFailed)
+			continue
+		}
+
+		avg := st.Elapsed / time.Duration(st.NumReqs - st.NumFailed)
+		perMin := float64(st.NumSeqs) / st.Elapsed.Minutes()
+
+		InfoLog.Printf("    %d sequences: %d requests (%d failed), %s per request, %.1f sequences per minute",
+			size, st.NumReqs, st.NumFailed, avg.Round(time.Millisecond), perMin)
+	}
+}
+
+// Predictor that tells the sizer how long its requests take and how they end
+type sizedPredictor struct {
+	Predictor
+	sizer	*ChunkSizer
+}
+
+func (sp *sizedPredictor) Predict(batch *Batch, algos uint8) ([]*SeqResult, error) {
+
+	start := time.Now()
+	results, err := sp.Predictor.Predict(batch, algos)
+	sp.sizer.Observe(len(batch.Seqs), time.Since(start), err)
+
+	return results, err
+}
+
+// Whether the request failed for being too big: it timed out, came back incomplete or
+// CAMP rejected it for having too many sequences
+func tooBig(err error) bool {
+
+	var pred *PredictError
+
+	if errors.As(err, &pred) && pred.Timeout {
+		return true
+	}
+
+	if errors.Is(err, ErrIncomplete) {
+		return true
+	}
+
+	return tooManySeqs(err)
+}
+
+// Whether CAMP rejected the request for having too many sequences
+func tooManySeqs(err error) bool {
+
+	var pe *PageError
+
+	if errors.As(err, &pe) && errors.Is(pe.Kind, ErrServerRejected) {
+
+		detail := strings.ToLower(pe.Detail)
+
+		for _, msg := range LIMITMESSAGES {
+			if strings.Contains(detail, msg) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
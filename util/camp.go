@@ -0,0 +1,753 @@
+package util
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"bytes"
+	"mime/multipart"
+	"os"
+	"net/http"
+	. "bitbucket.org/germelcar/campred/common"
+	"bufio"
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+	"strconv"
+	"sort"
+	"io/ioutil"
+	"time"
+	"fmt"
+	"errors"
+)
+
+// Predictor using the HTML form of the CAMP server
+type CampPredictor struct {
+	URL			string
+	Timeout		time.Duration
+	Keep		bool	// Write the responses (".camp" files) next to the splitted files
+	Verbose		bool
+
+	parseCh		chan bool	// Limit of responses parsed concurrently (number of threads)
+}
+
+func NewCampPredictor(url string, timeout time.Duration, numThreads int, keep, verbose bool) *CampPredictor {
+	return &CampPredictor{
+		URL: url,
+		Timeout: timeout,
+		Keep: keep,
+		Verbose: verbose,
+		parseCh: make(chan bool, numThreads),
+	}
+}
+
+func (cp *CampPredictor) Name() string {
+	return "CAMP (" + cp.URL + ")"
+}
+
+func (cp *CampPredictor) Predict(batch *Batch, algos uint8) ([]*SeqResult, error) {
+
+	buff, err := cp.send(batch, algos)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cp.Verbose {
+		InfoLog.Printf("Parsing response of %s", batch.Name)
+	}
+
+	// The responses are received concurrently, but parsing them is CPU bound
+	cp.parseCh <- true
+	seqs, err := parseResponse(buff, batch.Name, len(batch.Seqs), algos, cp.Verbose)
+	<-cp.parseCh
+
+	// Sending the request again will not change an error message or a page that can not be parsed.
+	// The unknown page is kept for checking it (and adding it to the saved pages, see camp_test.go)
+	if errors.Is(err, ErrLayoutChanged) {
+		writeLayoutPage(batch.Name, buff)
+	}
+
+	if errors.Is(err, ErrServerRejected) || errors.Is(err, ErrLayoutChanged) {
+		return nil, permanentError(err)
+	}
+
+	// The results of the sequences that came complete are given anyway (see Predictor)
+	if err != nil {
+		return seqs, err
+	}
+
+	// If keep intermediate files is set, then, write the response
+	// with extension ".camp"
+	if cp.Keep {
+		writeResponse(batch.Name, buff, cp.Verbose)
+	}
+
+	return seqs, nil
+}
+
+// Send the sequences of the batch as the file of the form and return the body of the response
+func (cp *CampPredictor) send(batch *Batch, algos uint8) ([]byte, error) {
+
+	var b bytes.Buffer
+	mp := multipart.NewWriter(&b)
+
+	fw, err := mp.CreateFormFile("userfile", batch.Name)
+
+	if err != nil {
+		return nil, permanentError(errors.New(fmt.Sprintf("Error creating HTTP POST Form for file %s: %s", batch.Name, err)))
+	}
+
+	// Only the IDs are sent, the descriptions are not needed for predicting
+	wrt := bio.NewWriter(fw)
+	wrt.IDOnly = true
+
+	for _, fs := range batch.Seqs {
+
+		err = wrt.Write(fs)
+
+		if err != nil {
+			return nil, permanentError(errors.New(fmt.Sprintf("Error writting sequences of file %s: %s", batch.Name, err)))
+		}
+	}
+
+	err = wrt.Flush()
+
+	if err != nil {
+		return nil, permanentError(errors.New(fmt.Sprintf("Error writting sequences of file %s: %s", batch.Name, err)))
+	}
+
+	// Add algorithms
+	err = addAlgorithms(algos, mp)
+
+	if err != nil {
+		return nil, permanentError(errors.New(fmt.Sprintf("Error adding algorithms for file %s: %s", batch.Name, err)))
+	}
+
+	mp.Close()
+
+	req, err := http.NewRequest("POST", cp.URL, &b)
+
+	if err != nil {
+		return nil, permanentError(errors.New(fmt.Sprintf("Error sending POST request for file %s: %s", batch.Name, err)))
+	}
+
+	req.Header.Set("Content-Type", mp.FormDataContentType())
+	httpClient := http.Client{Timeout: cp.Timeout}
+	res, err := httpClient.Do(req)
+
+	if err != nil {
+		return nil, netError(errors.New(fmt.Sprintf("Error with HTTP response for file %s: %s", batch.Name, err)), err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, httpStatusError(errors.New(fmt.Sprintf("Error with HTPP responde for file %s. Response code: %d",
+			batch.Name, res.StatusCode)), res)
+	}
+
+	buff, err := ioutil.ReadAll(res.Body)
+
+	if err != nil {
+		return nil, netError(errors.New(fmt.Sprintf(
+			"Error while extracting the body response for parsing it for file %s: %s", batch.Name, err)), err)
+	}
+
+	return buff, nil
+}
+
+func writeResponse(fileName string, buff []byte, verbose bool) {
+
+	newName := fileName + RESPONSEEXT
+
+	if verbose {
+		InfoLog.Printf("Writting response for %s (%s)", fileName, newName)
+	}
+
+	ofile, err := os.Create(newName)
+
+	if err != nil {
+		WarningLog.Printf("Error while creating response file for file %s: %s", fileName, err)
+		return
+	}
+
+	defer ofile.Close()
+
+	w := bufio.NewWriter(ofile)
+	defer w.Flush()
+	_, err = w.Write(buff)
+
+	if err != nil {
+		WarningLog.Printf("Error while writting response body for file %s: %s", fileName, err)
+	}
+
+}
+
+// Write the page with an unexpected layout next to the splitted file
+func writeLayoutPage(fileName string, buff []byte) {
+
+	newName := fileName + LAYOUTEXT
+	err := ioutil.WriteFile(newName, buff, 0644)
+
+	if err != nil {
+		WarningLog.Printf("Error while writting unexpected page for file %s: %s", fileName, err)
+		return
+	}
+
+	WarningLog.Printf("The page of CAMP for %s is not the expected one. It was written to %s", fileName, newName)
+}
+
+func addAlgorithms(algos uint8, mp *multipart.Writer) error {
+
+	// Adding the algorithms to use for prediction
+	// Iterate over the flags while adding each algorithm
+	// and removing it from the flags
+	for algos != 0 {
+
+		fw, err := mp.CreateFormField("algo[]")
+
+		if err != nil {
+			return err
+		}
+
+		if algos & SVM == SVM {
+
+			_, err = fw.Write([]byte("svm"))
+
+			if err != nil {
+				return err
+			}
+
+			algos &^= SVM
+			continue
+		}
+
+		if algos & ANN == ANN {
+
+			_, err = fw.Write([]byte("ann"))
+
+			if err != nil {
+				return err
+			}
+
+			algos &^= ANN
+			continue
+		}
+
+		if algos & RF == RF {
+
+			_, err = fw.Write([]byte("rf"))
+
+			if err != nil {
+				return err
+			}
+
+			algos &^= RF
+			continue
+		}
+
+		if algos & DA == DA {
+
+			_, err = fw.Write([]byte("da"))
+
+			if err != nil {
+				return err
+			}
+
+			algos &^= DA
+			continue
+		}
+
+	}
+
+	return nil
+}
+
+// Errors of the results pages of CAMP (see PageError)
+var (
+	ErrLayoutChanged	= errors.New("unexpected layout of the CAMP page")	// CAMP has changed its pages, resending will not help
+	ErrServerRejected	= errors.New("request rejected by CAMP")			// e.g. an invalid sequence, or too many sequences
+	ErrIncomplete		= errors.New("incomplete response")				// e.g. empty tables or a truncated page
+)
+
+// Messages (in lowercase) of the pages of CAMP that reject the request
+var REJECTMESSAGES = []string{"invalid sequence", "limit exceeded", "not in fasta format", "maximum number of sequences",
+	"no sequence"}
+
+// Messages of the pages of CAMP rejecting a request for having too many sequences (see ChunkSizer)
+var LIMITMESSAGES = []string{"limit exceeded", "maximum number of sequences"}
+
+// Messages of the pages of a busy (or broken) server, which could answer if the request is sent again
+var BUSYMESSAGES = []string{"temporarily unavailable", "too many connections", "try again later",
+	"internal server error", "timed out"}
+
+// Error of a results page: its kind (ErrLayoutChanged, ErrServerRejected or ErrIncomplete) and what was found.
+// Use errors.Is for its kind
+type PageError struct {
+	Kind	error
+	Detail	string
+	File	string	// Splitted file of the request (if known)
+}
+
+func (e *PageError) Error() string {
+
+	if e.File == "" {
+		return fmt.Sprintf("%s: %s", e.Kind, e.Detail)
+	}
+
+	return fmt.Sprintf("%s: %s (%s)", e.Kind, e.Detail, e.File)
+}
+
+func (e *PageError) Unwrap() error {
+	return e.Kind
+}
+
+func pageError(kind error, format string, args ...interface{}) error {
+	return &PageError{Kind: kind, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Results page of CAMP as parsed, before checking that it has the results requested
+type CampResponse struct {
+	Titles		[]string		// Titles of the results ("Results with ..."), in the order of the page
+	Algos		[]uint8			// Algorithm of each title
+	NumRows		map[uint8]int	// Rows with a class per algorithm
+	Seqs		[]*SeqResult	// Sorted by their number in the request (Index) or their ID
+	Warnings	[]string		// e.g. probabilities that are not a number
+	Truncated	bool			// The page has no end ("</html>")
+}
+
+// Algorithm of the title of its results (0 if it is not known)
+func titleAlgo(title string) uint8 {
+
+	switch {
+
+	case strings.Contains(title, "Support"):
+		return SVM
+
+	case strings.Contains(title, "Artificial"):
+		return ANN
+
+	case strings.Contains(title, "Random"):
+		return RF
+
+	case strings.Contains(title, "Discriminant"):
+		return DA
+
+	}
+
+	return 0
+}
+
+// An unexpected page. If it was truncated, the unexpected part is only where it was cut
+func (cr *CampResponse) layoutError(format string, args ...interface{}) error {
+
+	if cr.Truncated {
+		return cr.incompleteError(format, args...)
+	}
+
+	return pageError(ErrLayoutChanged, format, args...)
+}
+
+func (cr *CampResponse) incompleteError(format string, args ...interface{}) error {
+
+	if cr.Truncated {
+		return pageError(ErrIncomplete, "truncated page, " + format, args...)
+	}
+
+	return pageError(ErrIncomplete, format, args...)
+}
+
+// Line of the text of the page with the message (if any)
+func findMessage(text string, messages []string) string {
+
+	for _, line := range strings.Split(text, "\n") {
+
+		lower := strings.ToLower(line)
+
+		for _, m := range messages {
+			if strings.Contains(lower, m) {
+				return strings.Join(strings.Fields(line), " ")
+			}
+		}
+	}
+
+	return ""
+}
+
+// Columns of a results table by the texts of its header ("Seq. ID.", "Class" and, but for the ANN,
+// "AMP Probability"). The probability column is -1 if there is none
+func tableColumns(header *goquery.Selection) (int, int, int, error) {
+
+	idCol, classCol, probCol := -1, -1, -1
+	cols := []string{}
+
+	header.Find("th").Each(func(i int, th *goquery.Selection) {
+
+		col := strings.ToLower(strings.TrimSpace(th.Text()))
+		cols = append(cols, col)
+
+		switch {
+
+		case strings.Contains(col, "id") && idCol < 0:
+			idCol = i
+
+		case strings.Contains(col, "class") && classCol < 0:
+			classCol = i
+
+		case strings.Contains(col, "prob") && probCol < 0:
+			probCol = i
+
+		}
+	})
+
+	if idCol < 0 || classCol < 0 {
+		return 0, 0, 0, pageError(ErrLayoutChanged, "unknown columns of the results: %q", cols)
+	}
+
+	return idCol, classCol, probCol, nil
+}
+
+// Parse the table of results of an algorithm
+func (cr *CampResponse) parseTable(table *goquery.Selection, algo uint8, seqs map[string]*SeqResult) error {
+
+	rows := table.Find("tr")
+
+	if rows.Length() == 0 {
+		return cr.layoutError("no rows in the results of %s", AlgoName(algo))
+	}
+
+	idCol, classCol, probCol, err := tableColumns(rows.First())
+
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var rowErr error
+
+	rows.Slice(1, rows.Length()).EachWithBreak(func(i int, row *goquery.Selection) bool {
+
+		cells := row.Find("td")
+
+		if cells.Length() <= idCol || cells.Length() <= classCol {
+			rowErr = cr.layoutError("row %d of the results of %s with %d columns", i + 1, AlgoName(algo),
+				cells.Length())
+			return false
+		}
+
+		label := strings.TrimSpace(cells.Eq(idCol).Text())
+		class := strings.TrimSpace(cells.Eq(classCol).Text())
+
+		if label == "" || (class != AMP && class != "NAMP") {
+			rowErr = cr.layoutError("row %d of the results of %s is %q", i + 1, AlgoName(algo),
+				strings.Join(strings.Fields(row.Text()), " "))
+			return false
+		}
+
+		if seen[label] {
+			rowErr = pageError(ErrLayoutChanged, "sequence %s twice in the results of %s", label, AlgoName(algo))
+			return false
+		}
+
+		seen[label] = true
+		cr.NumRows[algo]++
+
+		// Keep the class and, if the algorithm gives it, the probability
+		ar := AlgoResult{Class: class}
+
+		if probCol >= 0 && probCol < cells.Length() {
+
+			value := strings.TrimSpace(cells.Eq(probCol).Text())
+			prob, err := strconv.ParseFloat(value, 64)
+
+			if err != nil {
+				cr.Warnings = append(cr.Warnings, fmt.Sprintf(
+					"Error while parsing probability (%s). Sequence: %s\tAlgorithm: %s", value, label, AlgoName(algo)))
+			} else {
+				ar.Prob = prob
+				ar.HasProb = true
+			}
+		}
+
+		if _, ok := seqs[label]; !ok {
+			seqs[label] = newLabelResult(label)
+		}
+
+		seqs[label].Algos[algo] = ar
+
+		return true
+	})
+
+	return rowErr
+}
+
+// Parse the results page. The page has a cell ("table.corner tr td") with the title of the results of each
+// algorithm ("p strong") followed by their table, whose first row is the header. The sequences are identified
+// by the "Seq. ID" column: its number in the request or, if it is not a number, its ID.
+//
+// The errors are PageError: ErrServerRejected if the page is an error message of CAMP, ErrIncomplete if it
+// could be answered if the request is sent again (e.g. a truncated page) and ErrLayoutChanged if the page
+// is not like the ones known
+func ParseResponse(buff []byte) (*CampResponse, error) {
+
+	cr := &CampResponse{NumRows: make(map[uint8]int)}
+	seqs := make(map[string]*SeqResult)
+
+	if len(bytes.TrimSpace(buff)) == 0 {
+		return nil, pageError(ErrIncomplete, "empty page")
+	}
+
+	cr.Truncated = !bytes.Contains(bytes.ToLower(buff), []byte("</html>"))
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(buff))
+
+	if err != nil {
+		return nil, pageError(ErrLayoutChanged, "%s", err)
+	}
+
+	var parseErr error
+
+	doc.Find("table.corner tr td").EachWithBreak(func(i int, s *goquery.Selection) bool {
+
+		// Titles "Results with [ALGORITHM]" where [ALGORITHM] is: SVM, ANN, RF or DA
+		titles := s.Find("p strong").FilterFunction(func(i2 int, s2 *goquery.Selection) bool {
+			return strings.Contains(s2.Text(), "Results")
+		})
+
+		// The cells of the tables of results have neither titles nor tables
+		tables := s.Find("table")
+
+		if titles.Length() == 0 && tables.Length() == 0 {
+			return true
+		}
+
+		if titles.Length() != tables.Length() {
+			parseErr = cr.layoutError("%d titles of results, but %d tables", titles.Length(), tables.Length())
+			return false
+		}
+
+		titles.EachWithBreak(func(i2 int, s2 *goquery.Selection) bool {
+
+			title := strings.TrimSpace(s2.Text())
+			algo := titleAlgo(title)
+
+			if algo == 0 {
+				parseErr = pageError(ErrLayoutChanged, "results of an unknown algorithm: %s", title)
+				return false
+			}
+
+			if _, ok := cr.NumRows[algo]; ok {
+				parseErr = pageError(ErrLayoutChanged, "results of %s twice", AlgoName(algo))
+				return false
+			}
+
+			cr.Titles = append(cr.Titles, title)
+			cr.Algos = append(cr.Algos, algo)
+			cr.NumRows[algo] = 0
+			parseErr = cr.parseTable(tables.Eq(i2), algo, seqs)
+
+			return parseErr == nil
+		})
+
+		return parseErr == nil
+	})
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	// No results: an error message of the server, or a page not known
+	if len(cr.Titles) == 0 {
+
+		text := doc.Find("body").Text()
+
+		if msg := findMessage(text, REJECTMESSAGES); msg != "" {
+			return nil, pageError(ErrServerRejected, "%s", msg)
+		}
+
+		if msg := findMessage(text, BUSYMESSAGES); msg != "" {
+			return nil, pageError(ErrIncomplete, "no results, the server says: %s", msg)
+		}
+
+		return nil, cr.layoutError("no results in the page")
+	}
+
+	// Whether each sequence is an AMP is decided once all the responses
+	// have been parsed (see Consensus)
+	for _, sr := range seqs {
+		cr.Seqs = append(cr.Seqs, sr)
+	}
+
+	sort.Slice(cr.Seqs, func(i, j int) bool {
+		if cr.Seqs[i].Index != cr.Seqs[j].Index {
+			return cr.Seqs[i].Index < cr.Seqs[j].Index
+		}
+
+		return cr.Seqs[i].ID < cr.Seqs[j].ID
+	})
+
+	return cr, nil
+}
+
+// Check that the page has the results of the "numSeqs" sequences for the algorithms requested, and
+// only them. Sometimes the tables come empty or with some rows missing
+func (cr *CampResponse) Check(numSeqs int, algos uint8) error {
+
+	for _, algo := range cr.Algos {
+		if algos & algo != algo {
+			return pageError(ErrLayoutChanged, "results of %s, which was not requested", AlgoName(algo))
+		}
+	}
+
+	for _, algo := range ALGORITHMS {
+
+		if algos & algo != algo {
+			continue
+		}
+
+		numRows, ok := cr.NumRows[algo]
+
+		switch {
+
+		case !ok:
+			return cr.incompleteError("no results of %s", AlgoName(algo))
+
+		case numRows < numSeqs:
+			return cr.incompleteError("%d of %d sequences with results of %s", numRows, numSeqs, AlgoName(algo))
+
+		case numRows > numSeqs:
+			return pageError(ErrLayoutChanged, "%d results of %s for %d sequences", numRows, AlgoName(algo), numSeqs)
+
+		}
+	}
+
+	return nil
+}
+
+// Text of the parsed page, one line per sequence with the results of each algorithm (see the golden
+// files of the parser, testdata/camp)
+func (cr *CampResponse) String() string {
+
+	var b strings.Builder
+
+	b.WriteString("algorithms:")
+
+	for _, algo := range cr.Algos {
+		b.WriteString(" " + AlgoName(algo))
+	}
+
+	b.WriteString("\nrows:")
+
+	for _, algo := range ALGORITHMS {
+		if n, ok := cr.NumRows[algo]; ok {
+			fmt.Fprintf(&b, " %s=%d", AlgoName(algo), n)
+		}
+	}
+
+	b.WriteString("\n")
+
+	for _, w := range cr.Warnings {
+		b.WriteString("warning: " + w + "\n")
+	}
+
+	for _, sr := range cr.Seqs {
+
+		label := sr.ID
+
+		if sr.Index > 0 {
+			label = fmt.Sprint(sr.Index)
+		}
+
+		b.WriteString(label)
+
+		for _, algo := range ALGORITHMS {
+
+			ar, ok := sr.Algos[algo]
+
+			if !ok {
+				continue
+			}
+
+			if ar.HasProb {
+				fmt.Fprintf(&b, "\t%s=%s:%v", AlgoName(algo), ar.Class, ar.Prob)
+			} else {
+				fmt.Fprintf(&b, "\t%s=%s", AlgoName(algo), ar.Class)
+			}
+		}
+
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// Parse the results page of a request of "numSeqs" sequences and the algorithms given
+func parseResponse(buff []byte, fileName string, numSeqs int, algos uint8, verbose bool) ([]*SeqResult, error) {
+
+	cr, err := ParseResponse(buff)
+
+	if err == nil {
+
+		for _, w := range cr.Warnings {
+			WarningLog.Printf("%s. File: %s", w, fileName)
+		}
+
+		err = cr.Check(numSeqs, algos)
+	}
+
+	var pe *PageError
+
+	if errors.As(err, &pe) {
+		pe.File = fileName
+	}
+
+	// Some rows missing: the sequences with the results of all the algorithms can be kept
+	if errors.Is(err, ErrIncomplete) && cr != nil {
+		return completeResults(cr.Seqs, algos), err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if verbose {
+		InfoLog.Printf("Results of %d sequences parsed (%s)", len(cr.Seqs), fileName)
+	}
+
+	return cr.Seqs, nil
+}
+
+// Results with all the algorithms given
+func completeResults(seqs []*SeqResult, algos uint8) []*SeqResult {
+
+	complete := []*SeqResult{}
+
+	for _, sr := range seqs {
+
+		numAlgos := 0
+
+		for algo := range sr.Algos {
+			if algos & algo == algo {
+				numAlgos++
+			}
+		}
+
+		if numAlgos == NumAlgos(algos) {
+			complete = append(complete, sr)
+		}
+	}
+
+	return complete
+}
+
+// Result of the sequence with the label given in the "Seq. ID" column of the response
+func newLabelResult(label string) *SeqResult {
+
+	idx, err := strconv.Atoi(label)
+
+	if err == nil && idx > 0 {
+		return newSeqResult(idx)
+	}
+
+	sr := newSeqResult(0)
+	sr.ID = label
+
+	return sr
+}
@@ -0,0 +1,234 @@
+package util
+
+import (
+	. "bitbucket.org/germelcar/campred/common"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"errors"
+	"fmt"
+)
+
+// Saved results pages (NAME.html) and what the parser must give for them (NAME.golden)
+const (
+	PAGEEXT		= ".html"
+	GOLDENEXT	= ".golden"
+
+	CORPUSDIR	= "testdata/camp"
+)
+
+// With -update, the golden files are written with what the parser gives now (keeping their requests)
+var update = flag.Bool("update", false, "Write the golden files with what the parser gives now")
+
+// Request whose response is the saved page. It is the first line of the golden file, e.g.
+// "request: sequences=3 algorithms=svm,rf"
+type goldenRequest struct {
+	NumSeqs		int
+	Algos		uint8
+}
+
+func (gr goldenRequest) String() string {
+
+	names := []string{}
+
+	for _, algo := range []uint8{SVM, ANN, RF, DA} {
+		if gr.Algos & algo == algo {
+			names = append(names, strings.ToLower(AlgoName(algo)))
+		}
+	}
+
+	if len(names) == 0 {
+		names = append(names, "none")
+	}
+
+	return fmt.Sprintf("request: sequences=%d algorithms=%s", gr.NumSeqs, strings.Join(names, ","))
+}
+
+func parseGoldenRequest(line string) (goldenRequest, error) {
+
+	gr := goldenRequest{}
+	fields := strings.Fields(line)
+
+	if len(fields) != 3 || fields[0] != "request:" {
+		return gr, errors.New(fmt.Sprintf("Invalid request line: %s", line))
+	}
+
+	numSeqs, err := strconv.Atoi(strings.TrimPrefix(fields[1], "sequences="))
+
+	if err != nil || !strings.HasPrefix(fields[1], "sequences=") {
+		return gr, errors.New(fmt.Sprintf("Invalid number of sequences: %s", fields[1]))
+	}
+
+	gr.NumSeqs = numSeqs
+
+	for _, name := range strings.Split(strings.TrimPrefix(fields[2], "algorithms="), ",") {
+
+		if name == "none" {
+			continue
+		}
+
+		algo, ok := AlgoByName(name)
+
+		if !ok {
+			return gr, errors.New(fmt.Sprintf("Invalid algorithm: %s", name))
+		}
+
+		gr.Algos |= algo
+	}
+
+	return gr, nil
+}
+
+// Request of a page without golden file: the sequences and the algorithms found in it
+func guessRequest(cr *CampResponse) goldenRequest {
+
+	gr := goldenRequest{}
+
+	for _, algo := range cr.Algos {
+		gr.Algos |= algo
+	}
+
+	for _, n := range cr.NumRows {
+		if n > gr.NumSeqs {
+			gr.NumSeqs = n
+		}
+	}
+
+	return gr
+}
+
+// What the parser gives for the page: the parsed page (see CampResponse.String) and whether it has the
+// results of the request, or the error of the parser (even if it panics)
+func parsePage(buff []byte, gr *goldenRequest) (out string) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			out = fmt.Sprintf("panic: %v\n", r)
+		}
+	}()
+
+	cr, err := ParseResponse(buff)
+
+	if err != nil {
+		return fmt.Sprintf("error: %s\n", err)
+	}
+
+	if gr.Algos == 0 {
+		*gr = guessRequest(cr)
+	}
+
+	check := "ok"
+	err = cr.Check(gr.NumSeqs, gr.Algos)
+
+	if err != nil {
+		check = err.Error()
+	}
+
+	return cr.String() + "check: " + check + "\n"
+}
+
+// First line that differs between what was expected and what was got
+func firstDiff(expected, got string) string {
+
+	expLines := strings.Split(expected, "\n")
+	gotLines := strings.Split(got, "\n")
+
+	for i := 0; i < len(expLines) || i < len(gotLines); i++ {
+
+		var exp, g string
+
+		if i < len(expLines) {
+			exp = expLines[i]
+		}
+
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+
+		if exp != g {
+			return fmt.Sprintf("line %d: expected %q, got %q", i + 2, exp, g)
+		}
+	}
+
+	return ""
+}
+
+// What the parser gives for every saved page must be what its golden file says
+func TestParseResponse(t *testing.T) {
+
+	pages, err := filepath.Glob(filepath.Join(CORPUSDIR, "*" + PAGEEXT))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pages) == 0 {
+		t.Fatalf("No saved pages (%s) in %s", PAGEEXT, CORPUSDIR)
+	}
+
+	// A case per saved page, named as it
+	tests := make([]struct {
+		name		string
+		page		string
+		golden		string
+	}, len(pages))
+
+	for i, page := range pages {
+		tests[i].name = strings.TrimSuffix(filepath.Base(page), PAGEEXT)
+		tests[i].page = page
+		tests[i].golden = strings.TrimSuffix(page, PAGEEXT) + GOLDENEXT
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			buff, err := ioutil.ReadFile(tt.page)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gr goldenRequest
+			expected := ""
+			golden, err := ioutil.ReadFile(tt.golden)
+
+			if err == nil {
+
+				lines := strings.SplitN(string(golden), "\n", 2)
+				gr, err = parseGoldenRequest(lines[0])
+
+				if err != nil {
+					t.Fatalf("Golden file %s: %s", tt.golden, err)
+				}
+
+				if len(lines) > 1 {
+					expected = lines[1]
+				}
+
+			} else if !os.IsNotExist(err) || !*update {
+				t.Fatal(err)
+			}
+
+			got := parsePage(buff, &gr)
+
+			if *update {
+
+				err = ioutil.WriteFile(tt.golden, []byte(gr.String() + "\n" + got), 0644)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return
+			}
+
+			if got != expected {
+				t.Errorf("%s: %s", tt.golden, firstDiff(expected, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package util
+
+import (
+	. "bitbucket.org/germelcar/campred/common"
+	"strconv"
+	"strings"
+	"errors"
+	"fmt"
+)
+
+const (
+	CONSENSUSALL		= "all"
+	CONSENSUSANY		= "any"
+	CONSENSUSMAJORITY	= "majority"
+	CONSENSUSATLEAST	= "at-least-"	// followed by the number of algorithms, e.g. "at-least-2"
+	CONSENSUSWEIGHTED	= "weighted"
+
+	DEFAULTMINSCORE		= 0.5
+)
+
+// Rule to decide whether a sequence is an AMP from the results of the algorithms
+// specified by the user
+type Consensus struct {
+	Rule		string
+	AtLeast		int					// Only for "at-least-N"
+	Weights		map[uint8]float64	// Only for "weighted". Missing algorithms weight 1
+	MinScore	float64				// Only for "weighted"
+}
+
+// Parse a consensus rule: all, any, majority, at-least-N or weighted
+func ParseConsensus(value string, algos uint8) (Consensus, error) {
+
+	value = strings.ToLower(strings.TrimSpace(value))
+	cons := Consensus{Rule: value, Weights: make(map[uint8]float64), MinScore: DEFAULTMINSCORE}
+	totAlgos := NumAlgos(algos)
+
+	switch {
+
+	case value == CONSENSUSALL, value == CONSENSUSANY, value == CONSENSUSMAJORITY, value == CONSENSUSWEIGHTED:
+		return cons, nil
+
+	case strings.HasPrefix(value, CONSENSUSATLEAST):
+
+		n, err := strconv.Atoi(strings.TrimPrefix(value, CONSENSUSATLEAST))
+
+		if err != nil || n < 1 || n > totAlgos {
+			return cons, errors.New(fmt.Sprintf(
+				"Invalid consensus %s: the number of algorithms must be between 1 and %d", value, totAlgos))
+		}
+
+		cons.Rule = CONSENSUSATLEAST
+		cons.AtLeast = n
+
+		return cons, nil
+
+	}
+
+	return cons, errors.New(fmt.Sprintf("%s: %s", "Unknown consensus", value))
+}
+
+func (c Consensus) String() string {
+
+	switch c.Rule {
+
+	case CONSENSUSATLEAST:
+		return fmt.Sprintf("%s%d", CONSENSUSATLEAST, c.AtLeast)
+
+	case CONSENSUSWEIGHTED:
+		return fmt.Sprintf("%s (min. score %v)", CONSENSUSWEIGHTED, c.MinScore)
+
+	}
+
+	return c.Rule
+}
+
+func (c Consensus) weight(algo uint8) float64 {
+
+	w, ok := c.Weights[algo]
+
+	if !ok {
+		return 1
+	}
+
+	return w
+}
+
+// Whether the sequence is an AMP following the rule.
+//
+// The votes of the algorithms are given by AlgoResult.IsAMP (so the minimum probabilities are
+// taken into account), except for the weighted rule, where the vote of an algorithm is
+// its probability (or 1/0 for AMP/NAMP if it has no probability) times its weight.
+func (c Consensus) IsAMP(sr *SeqResult, algos uint8, minProbs MinProbs) bool {
+
+	totAlgos := NumAlgos(algos)
+	votes := 0
+	var score, totWeight float64
+
+	for _, algo := range ALGORITHMS {
+
+		if algos & algo != algo {
+			continue
+		}
+
+		ar, ok := sr.Algos[algo]
+
+		if !ok {
+			continue
+		}
+
+		if ar.IsAMP(algo, minProbs) {
+			votes++
+		}
+
+		w := c.weight(algo)
+		totWeight += w
+
+		if ar.HasProb {
+			score += w * ar.Prob
+		} else if ar.Class == AMP {
+			score += w
+		}
+	}
+
+	switch c.Rule {
+
+	case CONSENSUSANY:
+		return votes >= 1
+
+	case CONSENSUSMAJORITY:
+		return votes > totAlgos / 2
+
+	case CONSENSUSATLEAST:
+		return votes >= c.AtLeast
+
+	case CONSENSUSWEIGHTED:
+		return totWeight > 0 && score / totWeight >= c.MinScore
+
+	}
+
+	return votes == totAlgos // all
+}
+
+// Sequences predicted as AMP following the consensus rule once all the responses have been parsed
+func ApplyConsensus(results Results, algos uint8, minProbs MinProbs, cons Consensus) map[int]string {
+
+	preds := make(map[int]string)
+
+	for idx, sr := range results {
+		if cons.IsAMP(sr, algos, minProbs) {
+			preds[idx] = sr.ID
+		}
+	}
+
+	// Reporting the total number of sequences predicteds as AMP by the consensus
+	// of the algorithms specified by the user
+	InfoLog.Printf("A total of %d sequence(s) predicted(s) as AMP by the %d algorithm(s) (consensus: %s)",
+		len(preds), NumAlgos(algos), cons)
+
+	return preds
+}
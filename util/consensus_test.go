@@ -0,0 +1,133 @@
+package util
+
+import (
+	. "bitbucket.org/germelcar/campred/common"
+	"reflect"
+	"testing"
+)
+
+// Results of a sequence predicted as AMP by SVM (0.9) and DA (0.6), and as NAMP by RF (0.3) and
+// ANN (which gives no probability)
+func twoVotes(idx int, id string) *SeqResult {
+	return &SeqResult{Index: idx, ID: id, Algos: map[uint8]AlgoResult{
+		SVM: {Class: AMP, Prob: 0.9, HasProb: true},
+		RF: {Class: "NAMP", Prob: 0.3, HasProb: true},
+		DA: {Class: AMP, Prob: 0.6, HasProb: true},
+		ANN: {Class: "NAMP"},
+	}}
+}
+
+func TestParseConsensus(t *testing.T) {
+
+	tests := []struct {
+		value		string
+		algos		uint8
+		rule		string
+		atLeast		int
+		valid		bool
+	}{
+		{value: "all", algos: ALLALGOS, rule: CONSENSUSALL, valid: true},
+		{value: " ANY ", algos: ALLALGOS, rule: CONSENSUSANY, valid: true},
+		{value: "majority", algos: ALLALGOS, rule: CONSENSUSMAJORITY, valid: true},
+		{value: "weighted", algos: ALLALGOS, rule: CONSENSUSWEIGHTED, valid: true},
+		{value: "at-least-2", algos: ALLALGOS, rule: CONSENSUSATLEAST, atLeast: 2, valid: true},
+		{value: "at-least-4", algos: ALLALGOS, rule: CONSENSUSATLEAST, atLeast: 4, valid: true},
+		{value: "at-least-3", algos: SVM | RF},
+		{value: "at-least-0", algos: ALLALGOS},
+		{value: "at-least-two", algos: ALLALGOS},
+		{value: "most", algos: ALLALGOS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+
+			cons, err := ParseConsensus(tt.value, tt.algos)
+
+			if (err == nil) != tt.valid {
+				t.Fatalf("Error %v, expected valid: %v", err, tt.valid)
+			}
+
+			if tt.valid && (cons.Rule != tt.rule || cons.AtLeast != tt.atLeast) {
+				t.Errorf("Rule %s (at least %d), expected %s (at least %d)", cons.Rule, cons.AtLeast, tt.rule,
+					tt.atLeast)
+			}
+		})
+	}
+}
+
+func TestConsensus(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		cons		Consensus
+		algos		uint8
+		minProbs	MinProbs
+		isAMP		bool
+	}{
+		{name: "all", cons: Consensus{Rule: CONSENSUSALL}, algos: ALLALGOS},
+		{name: "all of the algorithms asked", cons: Consensus{Rule: CONSENSUSALL}, algos: SVM | DA, isAMP: true},
+		{name: "any", cons: Consensus{Rule: CONSENSUSANY}, algos: ALLALGOS, isAMP: true},
+		{name: "any without votes", cons: Consensus{Rule: CONSENSUSANY}, algos: RF | ANN},
+		{name: "majority needs more than half", cons: Consensus{Rule: CONSENSUSMAJORITY}, algos: ALLALGOS},
+		{name: "majority", cons: Consensus{Rule: CONSENSUSMAJORITY}, algos: SVM | RF | DA, isAMP: true},
+		{name: "at least 2", cons: Consensus{Rule: CONSENSUSATLEAST, AtLeast: 2}, algos: ALLALGOS, isAMP: true},
+		{name: "at least 3", cons: Consensus{Rule: CONSENSUSATLEAST, AtLeast: 3}, algos: ALLALGOS},
+		{name: "minimum probability takes a vote", cons: Consensus{Rule: CONSENSUSATLEAST, AtLeast: 2},
+			algos: ALLALGOS, minProbs: MinProbs{SVM: 0.95}},
+		{name: "minimum probability gives a vote", cons: Consensus{Rule: CONSENSUSATLEAST, AtLeast: 3},
+			algos: ALLALGOS, minProbs: MinProbs{RF: 0.25}, isAMP: true},
+		{name: "minimum probability without probability", cons: Consensus{Rule: CONSENSUSANY}, algos: ANN,
+			minProbs: MinProbs{ANN: 0.1}},
+		{name: "weighted below the score", cons: Consensus{Rule: CONSENSUSWEIGHTED, MinScore: 0.5},
+			algos: ALLALGOS},
+		{name: "weighted with a lower score", cons: Consensus{Rule: CONSENSUSWEIGHTED, MinScore: 0.4},
+			algos: ALLALGOS, isAMP: true},
+		{name: "weighted with weights", cons: Consensus{Rule: CONSENSUSWEIGHTED, MinScore: 0.5,
+			Weights: map[uint8]float64{SVM: 2}}, algos: ALLALGOS, isAMP: true},
+		{name: "weighted class without probability", cons: Consensus{Rule: CONSENSUSWEIGHTED, MinScore: 0.5,
+			Weights: map[uint8]float64{ANN: 0, RF: 0}}, algos: ALLALGOS, isAMP: true},
+		{name: "weighted with no weight", cons: Consensus{Rule: CONSENSUSWEIGHTED, MinScore: 0.5,
+			Weights: map[uint8]float64{SVM: 0, RF: 0, DA: 0, ANN: 0}}, algos: ALLALGOS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if isAMP := tt.cons.IsAMP(twoVotes(1, "seq1"), tt.algos, tt.minProbs); isAMP != tt.isAMP {
+				t.Errorf("AMP: %v, expected %v", isAMP, tt.isAMP)
+			}
+		})
+	}
+}
+
+// The sequences without results of all the algorithms only count the votes they have
+func TestApplyConsensus(t *testing.T) {
+
+	partial := twoVotes(2, "seq2")
+	delete(partial.Algos, RF)
+
+	results := Results{1: twoVotes(1, "seq1"), 2: partial, 3: &SeqResult{Index: 3, ID: "seq3",
+		Algos: map[uint8]AlgoResult{}}}
+
+	tests := []struct {
+		name		string
+		cons		Consensus
+		expected	map[int]string
+	}{
+		{name: "all", cons: Consensus{Rule: CONSENSUSALL}, expected: map[int]string{}},
+		{name: "any", cons: Consensus{Rule: CONSENSUSANY}, expected: map[int]string{1: "seq1", 2: "seq2"}},
+		{name: "weighted over the results given", cons: Consensus{Rule: CONSENSUSWEIGHTED, MinScore: 0.5},
+			expected: map[int]string{2: "seq2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			preds := ApplyConsensus(results, ALLALGOS, nil, tt.cons)
+
+			if !reflect.DeepEqual(preds, tt.expected) {
+				t.Errorf("Predicted as AMP %v, expected %v", preds, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package util
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	. "bitbucket.org/germelcar/campred/common"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"errors"
+	"fmt"
+)
+
+const (
+	RESPONSEEXT	= ".camp"
+	LAYOUTEXT	= ".layout.html"	// Page of a response that could not be parsed (see ErrLayoutChanged)
+)
+
+// Number of the splitted file in its name (e.g. "out_12.fasta" or "out_12.fasta.gz")
+var chunkNumRe = regexp.MustCompile(`_(\d+)\.fasta(\.(gz|bz2|zst))?$`)
+
+func chunkNum(fileName string) int {
+
+	m := chunkNumRe.FindStringSubmatch(fileName)
+
+	if m == nil {
+		return 0
+	}
+
+	n, _ := strconv.Atoi(m[1])
+
+	return n
+}
+
+// Prefix of the splitted file, e.g. "out" for "out_12.fasta" ("" if it is not a splitted file)
+func ChunkPrefix(fileName string) string {
+
+	loc := chunkNumRe.FindStringIndex(fileName)
+
+	if loc == nil {
+		return ""
+	}
+
+	return fileName[ : loc[0]]
+}
+
+// Sort the splitted files by their number, the shell sorts "out_10.fasta" before "out_2.fasta".
+// Files without number keep the order given
+func sortChunks(fileNames []string) []string {
+
+	sorted := append([]string{}, fileNames...)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return chunkNum(sorted[i]) < chunkNum(sorted[j])
+	})
+
+	return sorted
+}
+
+// Parse the responses kept (".camp" files written with --keep) of the splitted files, without
+// sending anything to the server. The splitted files carry the number of their sequences in the
+// input file, so any subset of the files of the run can be parsed.
+// Up to "numThreads" responses are parsed concurrently
+func ParseKept(fileNames []string, algos uint8, numThreads int, verbose bool) (Results, error) {
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	results := make(Results)
+	totFiles := len(fileNames)
+	totFaileds := 0
+	preqs := []*predRequest{}
+
+	// The sequences of every file are needed for matching the results of its response
+	for i, fname := range sortChunks(fileNames) {
+
+		seqs, err := bio.ReadSeqs(fname)
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error while reading file %s: %s", fname, err))
+		}
+
+		preqs = append(preqs, &predRequest{
+			FastaFile: bio.FastaFile{FileName: fname, NumSeqs: len(seqs)},
+			IdxFile: i + 1,
+			Status: STATUSPENDING,
+			Seqs: seqs,
+		})
+	}
+
+	limitCh := make(chan bool, numThreads)
+
+	for _, preq := range preqs {
+
+		limitCh <- true
+		wg.Add(1)
+
+		go func(preq *predRequest) {
+			defer func() {
+				<-limitCh
+			}()
+			defer wg.Done()
+
+			seqs, err := parseKept(preq, algos, totFiles, verbose)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				WarningLog.Println(err)
+				totFaileds++
+				return
+			}
+
+			for _, sr := range seqs {
+				results.Add(sr)
+			}
+
+		}(preq)
+	}
+
+	wg.Wait()
+
+	if totFaileds > 0 {
+		WarningLog.Printf("%d of %d files have failed to be parsed", totFaileds, totFiles)
+	}
+
+	return results, nil
+}
+
+func parseKept(preq *predRequest, algos uint8, totFiles int, verbose bool) ([]*SeqResult, error) {
+
+	responses, err := keptResponses(preq.FileName)
+
+	if err == nil && len(responses) == 0 {
+		err = errors.New("no response kept")
+	}
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Error while reading response for file %s: %s", preq.FileName, err))
+	}
+
+	if verbose {
+		InfoLog.Printf("Parsing response of %s (file %d of %d)", preq.FileName, preq.IdxFile, totFiles)
+	}
+
+	// The file could have been sent in parts (see subBatch), each with its response
+	results := []*SeqResult{}
+	seen := make(map[int]struct{})
+
+	for _, resp := range responses {
+
+		batch, err := keptBatch(preq, resp)
+
+		if err != nil {
+			return nil, err
+		}
+
+		buff, err := ioutil.ReadFile(resp)
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Error while reading response for file %s: %s", batch.Name, err))
+		}
+
+		seqs, err := parseResponse(buff, batch.Name, len(batch.Seqs), algos, verbose)
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = mapBatchResults(batch, seqs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// A part can be kept by several runs (see --resume)
+		for _, sr := range seqs {
+
+			if _, ok := seen[sr.Index]; !ok {
+				seen[sr.Index] = struct{}{}
+				results = append(results, sr)
+			}
+		}
+	}
+
+	if len(seen) < len(preq.Seqs) {
+		WarningLog.Printf("%d of the %d sequences of %s without response kept", len(preq.Seqs) - len(seen),
+			len(preq.Seqs), preq.FileName)
+	}
+
+	return results, nil
+}
+
+// Responses kept for the splitted file: the one of the whole file and the ones of its parts sent
+// alone (e.g. "out_1.fasta[11-15].camp", see subBatch)
+func keptResponses(fileName string) ([]string, error) {
+
+	dir := filepath.Dir(fileName)
+	base := filepath.Base(fileName)
+	infos, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	responses := []string{}
+
+	for _, info := range infos {
+
+		name := info.Name()
+
+		if name == base + RESPONSEEXT ||
+				(strings.HasPrefix(name, base + "[") && strings.HasSuffix(name, "]" + RESPONSEEXT)) {
+			responses = append(responses, filepath.Join(dir, name))
+		}
+	}
+
+	return responses, nil
+}
+
+// Sequences of the splitted file whose response was kept in the file given
+func keptBatch(preq *predRequest, response string) (*Batch, error) {
+
+	name := strings.TrimSuffix(response, RESPONSEEXT)
+
+	if name == preq.FileName {
+		return &Batch{Name: name, Seqs: preq.Seqs}, nil
+	}
+
+	nums, err := parseSeqRanges(strings.TrimSuffix(strings.TrimPrefix(name, preq.FileName + "["), "]"))
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid name of response %s: %s", response, err))
+	}
+
+	byNum := make(map[int]bio.FastaSeq)
+
+	for _, fs := range preq.Seqs {
+		byNum[fs.Num] = fs
+	}
+
+	batch := &Batch{Name: name}
+
+	for _, num := range nums {
+
+		fs, ok := byNum[num]
+
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Response %s for sequence %d, which is not in %s", response, num,
+				preq.FileName))
+		}
+
+		batch.Seqs = append(batch.Seqs, fs)
+	}
+
+	return batch, nil
+}
+
+// Numbers of the sequences as ranges (e.g. "4,6-9")
+func seqRanges(seqs []bio.FastaSeq) string {
+
+	ranges := []string{}
+
+	for i := 0; i < len(seqs); {
+
+		j := i
+
+		for j + 1 < len(seqs) && seqs[j + 1].Num == seqs[j].Num + 1 {
+			j++
+		}
+
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(seqs[i].Num))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", seqs[i].Num, seqs[j].Num))
+		}
+
+		i = j + 1
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+func parseSeqRanges(value string) ([]int, error) {
+
+	nums := []int{}
+
+	for _, r := range strings.Split(value, ",") {
+
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Invalid range of sequences: %s", r))
+		}
+
+		last := first
+
+		if len(bounds) == 2 {
+
+			last, err = strconv.Atoi(bounds[1])
+
+			if err != nil || last < first {
+				return nil, errors.New(fmt.Sprintf("Invalid range of sequences: %s", r))
+			}
+		}
+
+		for num := first; num <= last; num++ {
+			nums = append(nums, num)
+		}
+	}
+
+	return nums, nil
+}
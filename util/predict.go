@@ -2,395 +2,398 @@ package util
 
 
 import (
+	"net/http"
 	"sync"
-	"bitbucket.org/germelcar/campred/bio"
-	"bytes"
-	"mime/multipart"
-	"os"
+	"time"
 	"io"
-	"net/http"
-	. "bitbucket.org/germelcar/campred/common"
-	"bufio"
-	"github.com/PuerkitoBio/goquery"
-	"strings"
-	"strconv"
-	"io/ioutil"
-	"fmt"
 	"errors"
+	"fmt"
+	"bitbucket.org/germelcar/campred/bio"
+	. "bitbucket.org/germelcar/campred/common"
 )
 
 type predRequest struct {
 				bio.FastaFile
 	NumSent		int
 	IdxFile		int
-	PrevSeqs	int
+	Status		string
+	LastError	string
+	Results		[]*SeqResult
+	FailedSeqs	[]FailedSeq		// Sequences that could not be predicted when the request was splitted
+	Seqs		[]bio.FastaSeq	// Already in memory (stream mode). Otherwise, read from the splitted file
 }
 
-type predResponse struct {
-	buff	[]byte
-			*predRequest
+// Sequence of a request that has failed even when sent alone (or with the sequences that fail with it)
+type FailedSeq struct {
+	Num		int
+	ID		string
+	Error	string
 }
 
+func sendFile(preq *predRequest, predictor Predictor, algos uint8, policy RetryPolicy, sizer *ChunkSizer, totFiles int,
+		verbose bool, wgSend *sync.WaitGroup, limitCh chan bool, finishCh chan *predRequest) {
+
+	defer func() {
+		<-limitCh
+	}()
+	defer wgSend.Done()
+
+	// If the file can not be read, sending it again will not help
+	var err error
+	seqs := preq.Seqs
 
-func writeResponse(presp *predResponse, wg *sync.WaitGroup, verbose bool) {
-	defer wg.Done()
+	if seqs == nil {
 
-	if verbose {
-		InfoLog.Printf("Writting response for %s (%s)",
-			presp.FileName, presp.FileName + ".camp")
+		seqs, err = bio.ReadSeqs(preq.FileName)
+
+		if err != nil {
+			preq.LastError = fmt.Sprintf("Error reading file %s: %s", preq.FileName, err)
+			WarningLog.Printf("%s. Not sending it", preq.LastError)
+			preq.Status = STATUSFAILED
+			finishCh <- preq
+			return
+		}
 	}
 
-	newName := presp.FileName + ".camp"
-	ofile, err := os.Create(newName)
-	defer ofile.Close()
+	// The sequences are not needed anymore once the request has finished
+	preq.Seqs = nil
+	failed := sendPieces(preq, &Batch{Name: preq.FileName, Seqs: seqs}, predictor, algos, policy, sizer, totFiles,
+		verbose)
 
-	if err != nil {
-		WarningLog.Printf("Error while reading response body for file %s: %s", presp.FileName, err)
+	// Nothing predicted
+	if len(failed) > 0 && len(preq.Results) == 0 && len(preq.FailedSeqs) == 0 {
+		preq.LastError = failed[len(failed) - 1].err.Error()
+		preq.Status = STATUSFAILED
+		finishCh <- preq
 		return
 	}
 
-	w := bufio.NewWriter(ofile)
-	defer w.Flush()
-	_, err = w.Write(presp.buff)
+	for _, fb := range failed {
+		failSeqs(preq, fb.Batch, fb.err)
+	}
 
-	if err != nil {
-		WarningLog.Printf("Error while writting response body for file %s: %s", presp.FileName, err)
+	if len(preq.FailedSeqs) == 0 {
+		preq.LastError = ""
+		preq.Status = STATUSDONE
+		finishCh <- preq
+		return
 	}
 
+	preq.LastError = fmt.Sprintf("%d of %d sequences could not be predicted", len(preq.FailedSeqs), preq.NumSeqs)
+	preq.Status = STATUSFAILED
+	finishCh <- preq
 }
 
-func addAlgorithms(algos uint8, mp *multipart.Writer) error {
+// Batch that has failed without results
+type failedBatch struct {
+	*Batch
+	err		error
+}
 
-	// Adding the algorithms to use for prediction
-	// Iterate over the flags while adding each algorithm
-	// and removing it from the flags
-	for algos != 0 {
+// Send the sequences of the batch in requests of the size tuned by the sizer, which changes while they
+// are sent (adaptive mode). Without sizer, all of them in a request
+func sendPieces(preq *predRequest, batch *Batch, predictor Predictor, algos uint8, policy RetryPolicy,
+		sizer *ChunkSizer, totFiles int, verbose bool) []failedBatch {
 
-		fw, err := mp.CreateFormField("algo[]")
+	failed := []failedBatch{}
 
-		if err != nil {
-			return err
-		}
+	for first := 0; first < len(batch.Seqs); {
 
-		if algos & SVM == SVM {
+		last := len(batch.Seqs)
 
-			_, err = fw.Write([]byte("svm"))
+		if sizer != nil && first + sizer.Size() < last {
+			last = first + sizer.Size()
+		}
 
-			if err != nil {
-				return err
-			}
+		piece := batch
 
-			algos &^= SVM
-			continue
+		if first > 0 || last < len(batch.Seqs) {
+			piece = subBatch(preq.FileName, batch.Seqs[first : last])
 		}
 
-		if algos & ANN == ANN {
+		first = last
+		failed = append(failed, sendSeqs(preq, piece, predictor, algos, policy, sizer, totFiles, verbose)...)
+	}
 
-			_, err = fw.Write([]byte("ann"))
+	return failed
+}
 
-			if err != nil {
-				return err
-			}
+// Send the sequences of the batch, adding their results to the request. If it keeps failing, the batch
+// is splitted (see bisectBatch) or, if some of its sequences were predicted, the ones left are added to
+// the failed sequences of the request. Returns the batch if it has failed without results
+func sendSeqs(preq *predRequest, batch *Batch, predictor Predictor, algos uint8, policy RetryPolicy,
+		sizer *ChunkSizer, totFiles int, verbose bool) []failedBatch {
 
-			algos &^= ANN
-			continue
-		}
+	results, rest, err := sendBatch(preq, batch, predictor, algos, policy, sizer, policy.MaxTries, totFiles, verbose)
+	preq.Results = append(preq.Results, results...)
 
-		if algos & RF == RF {
+	switch {
 
-			_, err = fw.Write([]byte("rf"))
+	case err == nil:
+		return nil
 
-			if err != nil {
-				return err
-			}
+	// Too big for the server: the sequences left are sent in requests of the new size
+	case sizer != nil && tooBig(err) && len(rest.Seqs) > sizer.Size():
+		return sendPieces(preq, rest, predictor, algos, policy, sizer, totFiles, verbose)
 
-			algos &^= RF
-			continue
-		}
+	// Often a single sequence makes the request fail. The halves of the sequences left are sent
+	// (and splitted again while they fail), so the rest of the sequences are predicted
+	case canBisect(rest, err, policy):
 
-		if algos & DA == DA {
+		WarningLog.Printf("Splitting the %d sequences left of %s (file %d of %d) to find the ones that make it fail",
+			len(rest.Seqs), batch.Name, preq.IdxFile, totFiles)
 
-			_, err = fw.Write([]byte("da"))
+		preq.Results = append(preq.Results, bisectBatch(preq, rest, predictor, algos, policy, totFiles, verbose)...)
 
-			if err != nil {
-				return err
-			}
+	// Some sequences were predicted, so the ones left are the failed ones
+	case len(results) > 0:
+		failSeqs(preq, rest, err)
 
-			algos &^= DA
-			continue
-		}
+	default:
+		return []failedBatch{{Batch: rest, err: err}}
 
 	}
 
 	return nil
 }
 
-func sendFile(preq *predRequest, algos uint8, numSend, totFiles, totAlgos int, keep, verbose bool,
-		wgSend, wgResp *sync.WaitGroup, limitCh chan bool, finishCh chan *predRequest, predsCh chan int) {
+// Send the batch up to "maxTries" times, until all its sequences are predicted. The complete results
+// of an incomplete response are kept, and only the sequences without them are sent again.
+// With a sizer, a batch too big for the server is not sent again (see sendSeqs).
+// Returns the results and, if the tries are over, the batch of the sequences left and the last error
+func sendBatch(preq *predRequest, batch *Batch, predictor Predictor, algos uint8, policy RetryPolicy,
+		sizer *ChunkSizer, maxTries, totFiles int, verbose bool) ([]*SeqResult, *Batch, error) {
 
-	defer func() {
-		<-limitCh
-	}()
-	defer wgSend.Done()
+	var err error
+	results := []*SeqResult{}
 
+	for tries := 0; tries < maxTries; {
 
-	SEND:
-
-		preq.NumSent++
-
-		if preq.NumSent > numSend {
-			finishCh <- preq
-			return
+		// Wait before resending, longer after each failed try (or what the server asked for)
+		if tries > 0 {
 
-		} else {
+			delay := policy.Delay(tries, retryAfter(err))
 
 			if verbose {
-
-				if preq.NumSent > 1 {
-					InfoLog.Printf(">>Resending %s by %d of %d times (file %d of %d)",
-						preq.FileName, preq.NumSent, numSend, preq.IdxFile, totFiles)
-				} else {
-					InfoLog.Printf("Sending %s by %d of %d times (file %d of %d)",
-						preq.FileName, preq.NumSent, numSend, preq.IdxFile, totFiles)
-				}
+				InfoLog.Printf(">>Waiting %s before resending %s", delay.Round(time.Millisecond), batch.Name)
 			}
-		}
-
-
-		var b bytes.Buffer
-		mp := multipart.NewWriter(&b)
 
-		f, err := os.Open(preq.FileName)
-		defer f.Close()
-
-		if err != nil {
-			WarningLog.Printf("Error sending file %s: %s", preq.FileName, err)
-			goto SEND
+			time.Sleep(delay)
 		}
 
-		fw, err := mp.CreateFormFile("userfile", preq.FileName)
-
-		if err != nil {
-			WarningLog.Printf("Error creating HTTP POST Form for file %s: %s", preq.FileName, err)
-			goto SEND
-		}
+		tries++
+		preq.NumSent++
 
-		_, err = io.Copy(fw, f)
+		if verbose {
 
-		if err != nil {
-			WarningLog.Println(err)
-			goto SEND
+			if tries > 1 {
+				InfoLog.Printf(">>Resending %s by %d of %d times (file %d of %d)",
+					batch.Name, tries, maxTries, preq.IdxFile, totFiles)
+			} else {
+				InfoLog.Printf("Sending %s by %d of %d times (file %d of %d)",
+					batch.Name, tries, maxTries, preq.IdxFile, totFiles)
+			}
 		}
 
-		// Add algorithms
-		addAlgorithms(algos, mp)
-		mp.Close()
+		var seqs []*SeqResult
+		var missing []bio.FastaSeq
+		seqs, err = predictor.Predict(batch, algos)
 
-		req, err := http.NewRequest("POST", CAMPREDURL, &b)
+		// The results are numbered from 1 in the batch. Set the number and ID of their
+		// sequences in the input file
+		if err == nil {
+			err = mapBatchResults(batch, seqs)
 
-		if err != nil {
-			WarningLog.Printf("Error sending POST request for file %s: %s", preq.FileName, err)
-			goto SEND
-		}
+		} else if len(seqs) > 0 {
 
-		req.Header.Set("Content-Type", mp.FormDataContentType())
-		httpClient := http.Client{Timeout: REQUESTTIMEOUT}
-		res, err := httpClient.Do(req)
+			var mapErr error
+			missing, mapErr = mapPartialResults(batch, seqs)
 
-		if err != nil {
-			WarningLog.Printf("Error with HTTP response for file %s: %s", preq.FileName, err)
-			goto SEND
+			if mapErr != nil {
+				err = mapErr
+				seqs = nil
+			} else if len(missing) == 0 {
+				err = nil
+			}
 		}
 
-		if res.StatusCode != 200 {
-			WarningLog.Printf("Error with HTPP responde for file %s. Response code: %d",
-				preq.FileName, res.StatusCode)
-
-			goto SEND
+		if err == nil {
+			return append(results, seqs...), nil, nil
 		}
 
-		buff, err := ioutil.ReadAll(res.Body)
-
-		if err != nil {
-			WarningLog.Printf("Error while extracting the body response for parsing it for file %s: %s",
-				preq.FileName, err)
-			goto SEND
-		}
+		// Keep what came complete and send only the rest
+		if len(seqs) > 0 && len(missing) > 0 {
 
-		if verbose {
+			WarningLog.Printf("Keeping the results of %d of the %d sequences of %s (%s)",
+				len(seqs), len(batch.Seqs), batch.Name, err)
 
-			if preq.NumSent > 1 {
-				InfoLog.Printf(">>Reparsing response of %s by %d of %d times (file %d of %d)",
-					preq.FileName, preq.NumSent, numSend, preq.IdxFile, totFiles)
-			} else {
-				InfoLog.Printf("Parsing response of %s by %d of %d times (file %d of %d)",
-					preq.FileName, preq.NumSent, numSend, preq.IdxFile, totFiles)
-			}
+			results = append(results, seqs...)
+			batch = subBatch(preq.FileName, missing)
 		}
 
-		presp := predResponse{buff: buff, predRequest: preq}
-		err = parseResponse(&presp, totAlgos, algos, keep, verbose, wgResp, predsCh)
-
-		if err != nil {
-			WarningLog.Println(err)
-			goto SEND
+		if sizer != nil && tooBig(err) && len(batch.Seqs) > sizer.Size() {
+			WarningLog.Printf("Try %d of %d failed: %s. Sending it again in requests of %d sequences",
+				tries, maxTries, err, sizer.Size())
+			break
 		}
 
-		finishCh <- preq
-}
-
-func parseResponse(presp *predResponse, totAlgos int, algos uint8, keep, verbose bool,
-	wgResp *sync.WaitGroup, predsCh chan int) error {
-
-	rdr := bytes.NewReader(presp.buff)
-	results := []string{}
-	numRows := 0
-	preds := make(map[int]uint8)
-	doc, err := goquery.NewDocumentFromReader(rdr)
+		if isPermanent(err) {
+			WarningLog.Printf("Try %d of %d failed: %s. Not sending it again (permanent error)",
+				tries, maxTries, err)
+			break
+		}
 
-	if err != nil {
-		return err
+		WarningLog.Printf("Try %d of %d failed: %s", tries, maxTries, err)
 	}
 
+	return results, batch, err
+}
 
-	doc.Find("table.corner tr td").Each(func(i int, s *goquery.Selection) {
-
+// Send the halves of the batch, splitting again the ones that fail, down to single sequences.
+// The sequences that could not be predicted are added to the failed sequences of the request
+func bisectBatch(preq *predRequest, batch *Batch, predictor Predictor, algos uint8, policy RetryPolicy,
+		totFiles int, verbose bool) []*SeqResult {
 
-		s.Find("p strong").Each(func(i2 int, s2 *goquery.Selection) {
+	results := []*SeqResult{}
+	half := len(batch.Seqs) / 2
 
-			// Append all "Results with [ALGORITHM]" where [ALGORITHM] is:
-			// SVM, ANN, RF or DA
-			if strings.Contains(s2.Text(),"Results") {
-				results = append(results, s2.Text())
-			}
+	for _, seqs := range [][]bio.FastaSeq{batch.Seqs[ : half], batch.Seqs[half : ]} {
 
-		})
+		sub := subBatch(preq.FileName, seqs)
+		subResults, rest, err := sendBatch(preq, sub, predictor, algos, policy, nil, policy.BisectTries, totFiles, verbose)
+		results = append(results, subResults...)
 
-		var currAlg uint8
-		var currAlgStr string
+		if err == nil {
+			continue
+		}
 
-		s.Find("table").Each(func(i2 int, s2 *goquery.Selection) {
+		if canBisect(rest, err, policy) {
 
-			s2.Find("tr").Each(func(i4 int, s3 *goquery.Selection) {
+			if verbose {
+				InfoLog.Printf("Splitting %s (%d sequences) again", rest.Name, len(rest.Seqs))
+			}
 
-				row := s3.Find("td").Text()
+			results = append(results, bisectBatch(preq, rest, predictor, algos, policy, totFiles, verbose)...)
+			continue
+		}
 
-				if row == "" {
+		failSeqs(preq, rest, err)
+	}
 
-					if strings.Contains(results[i2], "Support") {
-						currAlg = SVM
-						currAlgStr = "SVM"
+	return results
+}
 
-					} else if strings.Contains(results[i2], "Artificial") {
-						currAlg = ANN
-						currAlgStr = "ANN"
+// Add the sequences of the batch to the failed sequences of the request
+func failSeqs(preq *predRequest, batch *Batch, err error) {
 
-					} else if strings.Contains(results[i2], "Random") {
-						currAlg = RF
-						currAlgStr = "RF"
+	for _, fs := range batch.Seqs {
 
-					} else if strings.Contains(results[i2], "Discriminant") {
-						currAlg = DA
-						currAlgStr = "DA"
+		WarningLog.Printf("Sequence %s (%d) of %s could not be predicted: %s", fs.ID, fs.Num, preq.FileName, err)
+		preq.FailedSeqs = append(preq.FailedSeqs, FailedSeq{Num: fs.Num, ID: fs.ID, Error: err.Error()})
+	}
+}
 
-					}
+// Whether the sequences left of a failed batch can be splitted (see bisectable)
+func canBisect(batch *Batch, err error, policy RetryPolicy) bool {
+	return policy.BisectTries > 0 && batch != nil && len(batch.Seqs) > 1 && bisectable(err)
+}
 
-				} else {
+// Whether the error could be of some of the sequences of the request: CAMP has rejected them or not
+// answered all of them, the request was too large (413) or the server has failed with it (5xx). Any other
+// error, e.g. a wrong URL (404), a page that can not be parsed or a connection error, would be the same
+// with less sequences, so splitting the request would only flood the server
+func bisectable(err error) bool {
 
-					row = strings.TrimRight(strings.TrimLeft(row, " "), " ")
-					elements := strings.Fields(row)
+	if errors.Is(err, ErrServerRejected) || errors.Is(err, ErrIncomplete) {
+		return true
+	}
 
-					// The first row contains the columns: "Seq. ID", "Class" AND/OR "Probability"
-					// For the ANN, the "Probability" columns does not exist
-					if elements[1] == "AMP" || elements[1] == "NAMP" {
+	var pe *PredictError
 
-						numRows++
+	if errors.As(err, &pe) {
+		return pe.StatusCode == http.StatusRequestEntityTooLarge || pe.StatusCode >= 500
+	}
 
-						if elements[1] == "AMP" {
+	return false
+}
 
-							idx, err := strconv.ParseInt(elements[0], 10, 0)
+// Batch of some sequences of a splitted file, named by their numbers in the input file (e.g. "out_1.fasta[11-15]"
+// or "out_1.fasta[4,6-7]"), so their responses (see --keep) do not replace the one of the file and can be parsed
+// offline (see ParseKept)
+func subBatch(fileName string, seqs []bio.FastaSeq) *Batch {
+	return &Batch{Name: fmt.Sprintf("%s[%s]", fileName, seqRanges(seqs)), Seqs: seqs}
+}
 
-							if err != nil {
-								WarningLog.Printf(
-									"Error while parsing to int (%s). File: %s\tSequence: %d\tAlgorithm: %s",
-									elements[0], presp.FileName, idx, currAlgStr)
-							}
+// Open the input file for reading the sequences of the chunks in stream mode. The sequences
+// are validated again, so the chunks have the same sequences counted when the run started
+func openStream(inFile string, vd bio.Validation) (*bio.Reader, io.Closer, error) {
 
-							tid := int(idx) + presp.PrevSeqs
-							preds[tid] |= currAlg
+	fin, err := bio.OpenFile(inFile)
 
-						}
+	if err != nil {
+		return nil, nil, err
+	}
 
-					}
-				}
+	rdr := bio.NewReader(fin)
+	rdr.Validator = bio.NewValidator(vd)
 
-			})
+	return rdr, fin, nil
+}
 
-		})
+// Read the next "numSeqs" sequences of the input file (stream mode)
+func readChunk(rdr *bio.Reader, numSeqs int) ([]bio.FastaSeq, error) {
 
-	})
+	if rdr == nil {
+		return nil, errors.New("Input file not opened")
+	}
 
+	seqs := []bio.FastaSeq{}
 
-	// If the number of predicteds sequences (numRows) is different than number of sequences
-	// of the splitted file, that means that the tables were not complete.
-	//
-	// For example, sometimes the tables comes empty.
-	if len(results) != totAlgos || ((presp.NumSeqs * totAlgos) != numRows) {
+	for len(seqs) < numSeqs {
 
-		return errors.New(fmt.Sprintf(
-			"Response body incomplete with %d algorithms' results (%s)",
-			len(results), presp.FileName))
-	}
+		fs, err := rdr.Next()
 
-	totAmps := 0
-	for idx, count := range preds {
-		if count == algos {
-			totAmps++
-			predsCh <- idx
+		if err == io.EOF {
+			return nil, errors.New(fmt.Sprintf("Input file with less sequences than expected (%d)", numSeqs))
 		}
-	}
 
-	if verbose {
-		InfoLog.Printf("A total of %d sequences (of %d) predicted as AMP (%s)",
-			totAmps, presp.NumSeqs, presp.FileName)
-	}
+		if err != nil {
+			return nil, err
+		}
 
-	// If keep intermediate files is set, then, write the response
-	// with extension ".camp"
-	if keep {
-		wgResp.Add(1)
-		go writeResponse(presp, wgResp, verbose)
+		seqs = append(seqs, fs)
 	}
 
-	return nil
-
+	return seqs, nil
 }
 
-func Predict(files []bio.FastaFile, numSend int, algos uint8, keep, verbose bool) (map[int]struct{}) {
+// Send all the chunks (splitted files) of the run that are not done yet.
+//
+// In stream mode, the sequences of the chunks are read from the input file while sending them, so only
+// the chunks being sent are in memory, and the splitted files are only written if "keep" is set.
+//
+// With a sizer (adaptive mode), the chunks are sent in requests of the size tuned by it
+func Predict(state *JobState, predictor Predictor, policy RetryPolicy, sizer *ChunkSizer, numRequests int,
+		algos uint8, keep, verbose bool) (Results) {
 
 	var wg sync.WaitGroup
 	var wgSend sync.WaitGroup
-	var wgResp sync.WaitGroup
-
-	var totSeqs int
 
 	// Total request that have failed to be processed
 	totFaileds := 0
 
-	// Number of algorithms specified by the user. 4 for all (svm, ann, rf & da)
-	totAlgos := NumAlgos(algos)
-
-	// Total files to be processed
-	totFiles := len(files)
+	// Total files of the run and files to be processed (those not done by a previous run)
+	totFiles := len(state.Chunks)
+	totPending := totFiles - state.Count(STATUSDONE)
 
-	// Sequences predicteds as AMP by all the algorithms specified by the user and
-	// the requests already processeds
-	preds := make(map[int]struct{})
+	// The requests already processeds
 	finishes := []*predRequest{}
 
-	predsCh := make(chan int, 100)
+	// Class and probability of every sequence given by each algorithm
+	results := make(Results)
+
 	finishCh := make(chan *predRequest)
-	limitCh := make(chan bool, MAXREQUESTS)
+	limitCh := make(chan bool, numRequests)
 
 
 	// Add the finishes requests
@@ -398,16 +401,19 @@ func Predict(files []bio.FastaFile, numSend int, algos uint8, keep, verbose bool
 	// This slice could contains finishes requests mainly by two reasons:
 	//
 	// 1.- The request was processed and everything was OK
-	// 2.- The request was failed to be processed due to the times than the specified by "MAX_REQUESTS"
+	// 2.- The request was failed to be processed due to the times than the specified by the policy
+	//     or due to a permanent error
+	//
+	// Every finished request is recorded in the state file, so the run can be resumed
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		for i := 0; i < totFiles; i++ {
+		for i := 0; i < totPending; i++ {
 			f := <- finishCh
 			finishes = append(finishes, f)
 
-			if f.NumSent > numSend {
+			if f.Status == STATUSFAILED {
 				totFaileds++
 
 				if verbose {
@@ -416,40 +422,97 @@ func Predict(files []bio.FastaFile, numSend int, algos uint8, keep, verbose bool
 				}
 			}
 
+			for _, sr := range f.Results {
+				results.Add(sr)
+			}
+
+			err := state.Update(f)
+
+			if err != nil {
+				WarningLog.Printf("Error while writting state file %s: %s", state.FileName(), err)
+			}
+
 		}
 
 		close(finishCh)
-		close(predsCh)
 
 	}()
 
+	// The sizer is told how every request went
+	if sizer != nil {
+		predictor = &sizedPredictor{Predictor: predictor, sizer: sizer}
+	}
+
+	var rdr *bio.Reader
+
+	if state.Stream {
+
+		var fin io.Closer
+		var err error
+		rdr, fin, err = openStream(state.InFile, state.Validation)
+
+		if err != nil {
+			WarningLog.Printf("Error while opening input file %s: %s", state.InFile, err)
+		} else {
+			defer fin.Close()
+		}
+	}
+
 	//
-	// Add the predicteds sequences by all the algorithms specified by the user
+	// Iterate over all splitted fasta files and send them with a limit equals to "numRequests".
 	//
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+	for _, c := range state.Chunks {
+
+		// Take the sequences of the chunk from the input file (even if the chunk is done,
+		// its sequences must be skipped)
+		var seqs []bio.FastaSeq
+		var readErr error
 
-		for idx := range predsCh {
-			preds[idx] = struct{}{}
+		if state.Stream {
+			seqs, readErr = readChunk(rdr, c.NumSeqs)
 		}
 
-	}()
+		// Already processed by a previous run. Just take its results
+		if c.Status == STATUSDONE {
 
-	//
-	// Iterate over all splitted fasta files and send them with a limit equals to "MAX_REQUESTS" constant.
-	//
-	for i, f := range files {
+			if verbose {
+				InfoLog.Printf("Skipping %s (file %d of %d). Already processed", c.FileName, c.IdxFile, totFiles)
+			}
+
+			for _, sr := range c.Results {
+				results.Add(sr)
+			}
 
-		// The index of the splitted file (n of N). One based.
-		idxFile := i + 1
+			continue
+		}
 
-		// Make the request to be send
+		// Make the request to be send. Failed requests by a previous run have all the tries again
 		preq := &predRequest{
-			FastaFile: f,
+			FastaFile: bio.FastaFile{FileName: c.FileName, NumSeqs: c.NumSeqs},
 			NumSent: 0,
-			IdxFile: idxFile,
-			PrevSeqs: totSeqs,
+			IdxFile: c.IdxFile,
+			Status: STATUSPENDING,
+			Seqs: seqs,
+		}
+
+		if readErr != nil {
+			preq.LastError = fmt.Sprintf("Error reading the sequences of %s from %s: %s", c.FileName, state.InFile, readErr)
+			WarningLog.Printf("%s. Not sending it", preq.LastError)
+			preq.Status = STATUSFAILED
+			finishCh <- preq
+			continue
+		}
+
+		// The splitted files are needed for parsing the kept responses offline
+		if state.Stream && keep {
+
+			err := bio.WriteFile(c.FileName, seqs)
+
+			if err != nil {
+				WarningLog.Printf("Error while writting splitted file %s: %s", c.FileName, err)
+			} else if verbose {
+				InfoLog.Printf("Splitted %d sequences in file: %s", len(seqs), c.FileName)
+			}
 		}
 
 		// Take a "place" for the number of splitted files to be send concurrently and
@@ -458,28 +521,28 @@ func Predict(files []bio.FastaFile, numSend int, algos uint8, keep, verbose bool
 		wgSend.Add(1)
 
 		// Send the request
-		go sendFile(preq, algos, numSend, totFiles, totAlgos, keep, verbose,
-			&wgSend, &wgResp, limitCh, finishCh, predsCh)
-
-		// Update the number of sequences before of the splitted fasta file.
-		// This is for calculating the real index of the sequences from the results
-		// of request. For example:
-		//
-		// A multifasta of 10 sequences splitted in 2 files of 10 sequences each one,
-		// each response will get their sequences numbered from 1 to 10, but the sequences
-		// of the second file are 11 to 20 instead of 1 to 10.
-		totSeqs += f.NumSeqs
+		go sendFile(preq, predictor, algos, policy, sizer, totFiles, verbose, &wgSend, limitCh, finishCh)
 
 	}
 
-	// Wait all goroutines the send the request, write the response,
-	// get the predicteds sequences (by all algorithms specified by the user) and
+	// Wait all goroutines the send the request,
+	// get the results of the sequences and
 	// get the requests processes
 	wgSend.Wait()
-	wgResp.Wait()
 	wg.Wait()
 	close(limitCh)
 
+	// The log of the finished requests is compacted into the state file
+	err := state.Save()
+
+	if err != nil {
+		WarningLog.Printf("Error while writting state file %s: %s", state.FileName(), err)
+	}
+
+	if sizer != nil {
+		sizer.Report()
+	}
+
 	// Report the failed splitted files/requests
 	if totFaileds >= 1 {
 		var msg string
@@ -492,17 +555,20 @@ func Predict(files []bio.FastaFile, numSend int, algos uint8, keep, verbose bool
 		WarningLog.Println(msg)
 
 		for _, f := range finishes {
-			if f.NumSent > numSend {
-				WarningLog.Printf("%s (file %d of %d)", f.FileName, f.IdxFile, totFiles)
+
+			if f.Status != STATUSFAILED {
+				continue
+			}
+
+			WarningLog.Printf("%s (file %d of %d): %s", f.FileName, f.IdxFile, totFiles, f.LastError)
+
+			for _, fs := range f.FailedSeqs {
+				WarningLog.Printf("    %s (sequence %d): %s", fs.ID, fs.Num, fs.Error)
 			}
 		}
 
+		WarningLog.Printf("Run again with --resume to retry only the failed files")
 	}
 
-	// Reporting the total number of sequences predicteds as AMP by all the algorithms
-	// specified by the user
-	InfoLog.Printf("A total of %d sequence(s) predicted(s) as AMP by the %d algorithm(s)",
-		len(preds), totAlgos)
-
-	return preds
+	return results
 }
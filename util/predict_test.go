@@ -0,0 +1,281 @@
+package util
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	. "bitbucket.org/germelcar/campred/common"
+	"bitbucket.org/germelcar/campred/mock"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const ALLALGOS = SVM | ANN | RF | DA
+
+// Sequences sent in the tests (standard amino acids only, so the mock server accepts them)
+var testSeqs = []string{
+	"GLFDIVKKVVGALGSL",
+	"KWKLFKKIGAVLKVL",
+	"ACDEFGHIKLMNPQRS",
+	"FLPLLAGLAANFLPKIFCKITRKC",
+	"GIGKFLHSAKKFGKAFVGEIMNS",
+	"ILPWKWPWWPWRR",
+}
+
+// Policy with short delays, so the retries do not slow down the tests
+var testPolicy = RetryPolicy{MaxTries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond * 10, BisectTries: 3}
+
+// Mock server that fails the first "numFails" requests (as "failing" does) and answers the rest as
+// "healthy" does. Both have the same seed, so they give the same results
+type flakyServer struct {
+	failing		*mock.Server
+	healthy		*mock.Server
+	numFails	int32
+	numReqs		int32
+}
+
+func (fs *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if atomic.AddInt32(&fs.numReqs, 1) <= fs.numFails {
+		fs.failing.ServeHTTP(w, r)
+		return
+	}
+
+	fs.healthy.ServeHTTP(w, r)
+}
+
+func newMock(failures []string, delay time.Duration) *mock.Server {
+
+	failRate := 0.0
+
+	if len(failures) > 0 {
+		failRate = 1
+	}
+
+	return mock.NewServer("", mock.RULERANDOM, 1, failRate, failures, delay, false)
+}
+
+// Split the sequences in files of "chunkSize" sequences and predict them with the CAMP predictor
+// against the server
+func predictWith(t *testing.T, handler http.Handler, seqs []string, chunkSize int, policy RetryPolicy,
+	timeout time.Duration) (Results, *JobState) {
+
+	dir := t.TempDir()
+	files := []bio.FastaFile{}
+
+	for first := 0; first < len(seqs); first += chunkSize {
+
+		fseqs := []bio.FastaSeq{}
+
+		for i := first; i < first + chunkSize && i < len(seqs); i++ {
+			fseqs = append(fseqs, bio.FastaSeq{ID: fmt.Sprintf("seq%d", i + 1), Seq: seqs[i], Num: i + 1})
+		}
+
+		fileName := filepath.Join(dir, fmt.Sprintf("in_%d.fasta", len(files) + 1))
+		err := bio.WriteFile(fileName, fseqs)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files = append(files, bio.FastaFile{FileName: fileName, NumSeqs: len(fseqs)})
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	state := NewJobState(filepath.Join(dir, "in.state.json"), RunSettings{Algos: ALLALGOS, ChunkSize: chunkSize},
+		nil, files)
+	predictor := NewCampPredictor(ts.URL, timeout, 1, false, false)
+
+	return Predict(state, predictor, policy, nil, 2, ALLALGOS, false, false), state
+}
+
+// The failures the requests recover from by being sent again: all the sequences end up predicted, with
+// the results of a clean run
+func TestPredictRetries(t *testing.T) {
+
+	expected, _ := predictWith(t, newMock(nil, 0), testSeqs, 3, testPolicy, time.Second * 5)
+
+	if len(expected) != len(testSeqs) {
+		t.Fatalf("Clean run: %d of %d sequences predicted", len(expected), len(testSeqs))
+	}
+
+	tests := []struct {
+		name		string
+		failure		string
+		minElapsed	time.Duration
+	}{
+		{name: "internal error", failure: mock.FAILERROR},
+		{name: "busy with Retry-After", failure: mock.FAILBUSY, minElapsed: time.Second},
+		{name: "partial tables", failure: mock.FAILPARTIAL},
+		{name: "empty tables", failure: mock.FAILEMPTY},
+		{name: "truncated page", failure: mock.FAILTRUNCATED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			srv := &flakyServer{failing: newMock([]string{tt.failure}, 0), healthy: newMock(nil, 0), numFails: 1}
+			start := time.Now()
+			results, state := predictWith(t, srv, testSeqs, 3, testPolicy, time.Second * 5)
+			elapsed := time.Since(start)
+
+			if !reflect.DeepEqual(results, expected) {
+				t.Errorf("Results differ from the ones of a clean run: %d of %d sequences predicted",
+					len(results), len(testSeqs))
+			}
+
+			if n := state.Count(STATUSDONE); n != len(state.Chunks) {
+				t.Errorf("%d of %d files done", n, len(state.Chunks))
+			}
+
+			if elapsed < tt.minElapsed {
+				t.Errorf("Sent again after %s, but the server asked for %s", elapsed, tt.minElapsed)
+			}
+		})
+	}
+}
+
+// A request that always fails is sent as many times as the policy says, and its file is failed
+func TestPredictGivesUp(t *testing.T) {
+
+	srv := &flakyServer{failing: newMock([]string{mock.FAILERROR}, 0), healthy: newMock(nil, 0),
+		numFails: 1000}
+	policy := testPolicy
+	policy.BisectTries = 0
+
+	results, state := predictWith(t, srv, testSeqs, len(testSeqs), policy, time.Second * 5)
+
+	if len(results) != 0 {
+		t.Errorf("%d sequences predicted, expected none", len(results))
+	}
+
+	if state.Chunks[0].Status != STATUSFAILED {
+		t.Errorf("File %s, expected %s", state.Chunks[0].Status, STATUSFAILED)
+	}
+
+	if n := atomic.LoadInt32(&srv.numReqs); int(n) != policy.MaxTries {
+		t.Errorf("%d requests sent, expected %d", n, policy.MaxTries)
+	}
+}
+
+// A sequence that always makes the server fail is isolated by splitting the request, and the rest
+// of the sequences are predicted
+func TestPredictPoison(t *testing.T) {
+
+	srv := newMock(nil, 0)
+	srv.Poison = "KLMN"
+
+	results, state := predictWith(t, srv, testSeqs, len(testSeqs), testPolicy, time.Second * 5)
+
+	if len(results) != len(testSeqs) - 1 {
+		t.Errorf("%d sequences predicted, expected %d", len(results), len(testSeqs) - 1)
+	}
+
+	failed := state.Chunks[0].FailedSeqs
+
+	if len(failed) != 1 || failed[0].Num != 3 {
+		t.Fatalf("Failed sequences %v, expected only the sequence 3", failed)
+	}
+
+	if _, ok := results[3]; ok {
+		t.Errorf("Results for the poisoned sequence")
+	}
+}
+
+// A request that times out is given up by the client, and so by the server: it does not keep
+// answering after the client has gone (closing the server waits for its requests)
+func TestPredictTimeout(t *testing.T) {
+
+	delay := time.Minute
+	policy := testPolicy
+	policy.MaxTries = 1
+	policy.BisectTries = 0
+
+	start := time.Now()
+	results, state := predictWith(t, newMock([]string{mock.FAILSLOW}, delay), testSeqs, len(testSeqs), policy,
+		time.Millisecond * 200)
+	elapsed := time.Since(start)
+
+	if len(results) != 0 {
+		t.Errorf("%d sequences predicted, expected none", len(results))
+	}
+
+	if state.Chunks[0].Status != STATUSFAILED {
+		t.Errorf("File %s, expected %s", state.Chunks[0].Status, STATUSFAILED)
+	}
+
+	if elapsed >= delay {
+		t.Errorf("The server kept answering for %s after the client timed out", elapsed)
+	}
+}
+
+// A run resumed from its state file only sends the files that failed or were not sent, and ends with
+// the results of a clean run
+func TestPredictResume(t *testing.T) {
+
+	expected, _ := predictWith(t, newMock(nil, 0), testSeqs, 2, testPolicy, time.Second * 5)
+
+	// The file of the poisoned sequence fails, and the last one is left pending, as by an interrupted run
+	srv := newMock(nil, 0)
+	srv.Poison = "KLMN"
+	policy := testPolicy
+	policy.BisectTries = 0
+
+	_, state := predictWith(t, srv, testSeqs, 2, policy, time.Second * 5)
+
+	if state.Chunks[1].Status != STATUSFAILED {
+		t.Fatalf("File %s, expected %s", state.Chunks[1].Status, STATUSFAILED)
+	}
+
+	state.Chunks[2].Status = STATUSPENDING
+	state.Chunks[2].Results = nil
+
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadJobState(state.FileName())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(loaded.RunSettings, state.RunSettings) || !reflect.DeepEqual(loaded.Chunks, state.Chunks) {
+		t.Fatalf("Loaded state %+v, expected %+v", loaded.Chunks, state.Chunks)
+	}
+
+	// Other number of sequences per file gives other files
+	if err := loaded.Check(RunSettings{Algos: ALLALGOS, ChunkSize: 3}); err == nil {
+		t.Errorf("State of files of 2 sequences accepted for files of 3")
+	}
+
+	if err := loaded.Check(state.RunSettings); err != nil {
+		t.Fatal(err)
+	}
+
+	counter := &flakyServer{healthy: newMock(nil, 0)}
+	ts := httptest.NewServer(counter)
+	defer ts.Close()
+
+	results := Predict(loaded, NewCampPredictor(ts.URL, time.Second * 5, 1, false, false), testPolicy, nil, 2,
+		ALLALGOS, false, false)
+
+	if n := atomic.LoadInt32(&counter.numReqs); n != 2 {
+		t.Errorf("%d requests sent, expected 2 (the failed and the pending files)", n)
+	}
+
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Results differ from the ones of a clean run: %d of %d sequences predicted", len(results),
+			len(testSeqs))
+	}
+
+	if n := loaded.Count(STATUSDONE); n != len(loaded.Chunks) {
+		t.Errorf("%d of %d files done", n, len(loaded.Chunks))
+	}
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"errors"
+	"fmt"
+)
+
+// Sequences sent together in a request (e.g. a splitted file)
+type Batch struct {
+	Name	string	// Used for the messages and the files written by the predictor
+	Seqs	[]bio.FastaSeq
+}
+
+// Backend for predicting the sequences (e.g. the CAMP server). Predict returns the results
+// of the requested algorithms for every sequence of the batch, numbered from 1 in the order
+// of the batch or identified by the ID of the sequence. Predict is called concurrently by
+// Predict's scheduling.
+//
+// If the response is incomplete (ErrIncomplete), Predict can return the results of the sequences
+// that came complete with the error, so only the rest are sent again
+type Predictor interface {
+	Name() string
+	Predict(batch *Batch, algos uint8) ([]*SeqResult, error)
+}
+
+// Match the results returned by a predictor with the sequences of the batch (see mapPartialResults).
+// A sequence without result is an error
+func mapBatchResults(batch *Batch, results []*SeqResult) error {
+
+	missing, err := mapPartialResults(batch, results)
+
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		return errors.New(fmt.Sprintf("Results for %d of the %d sequences of %s",
+			len(batch.Seqs) - len(missing), len(batch.Seqs), batch.Name))
+	}
+
+	return nil
+}
+
+// Match the results returned by a predictor with the sequences of the batch, setting in every
+// result the number of its sequence in the input file and its ID. The predictor can identify the
+// sequences by their position in the batch (Index) or by their ID. A result that does not match
+// exactly one sequence of the batch is an error. Returns the sequences without result
+func mapPartialResults(batch *Batch, results []*SeqResult) ([]bio.FastaSeq, error) {
+
+	byID := make(map[string]int)
+
+	for i, fs := range batch.Seqs {
+		byID[fs.ID] = i + 1
+	}
+
+	seen := make(map[int]struct{})
+
+	for _, sr := range results {
+
+		idx := sr.Index
+
+		if idx == 0 {
+
+			var ok bool
+			idx, ok = byID[sr.ID]
+
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Result for sequence %s, which is not in %s", sr.ID, batch.Name))
+			}
+		}
+
+		if idx < 1 || idx > len(batch.Seqs) {
+			return nil, errors.New(fmt.Sprintf("Result for sequence %d out of the %d sequences of %s",
+				idx, len(batch.Seqs), batch.Name))
+		}
+
+		fs := batch.Seqs[idx - 1]
+
+		if sr.ID != "" && sr.ID != fs.ID {
+			return nil, errors.New(fmt.Sprintf("Result for sequence %s given for sequence %d (%s) of %s",
+				sr.ID, idx, fs.ID, batch.Name))
+		}
+
+		if _, ok := seen[idx]; ok {
+			return nil, errors.New(fmt.Sprintf("Duplicated result for sequence %d (%s) of %s", idx, fs.ID, batch.Name))
+		}
+
+		seen[idx] = struct{}{}
+		sr.Index = fs.Num
+		sr.ID = fs.ID
+	}
+
+	missing := []bio.FastaSeq{}
+
+	for i, fs := range batch.Seqs {
+		if _, ok := seen[i + 1]; !ok {
+			missing = append(missing, fs)
+		}
+	}
+
+	return missing, nil
+}
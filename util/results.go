@@ -0,0 +1,59 @@
+package util
+
+const AMP = "AMP"
+
+// Class and probability given by one algorithm to a sequence.
+// The ANN results of CAMP have no probability column, so HasProb is false for them
+type AlgoResult struct {
+	Class		string
+	Prob		float64
+	HasProb		bool
+}
+
+// All the algorithms' results of a sequence. Index is the one based position
+// of the sequence in the input file and ID its ID, so the results can be checked
+// against the sequence they are for
+type SeqResult struct {
+	Index		int
+	ID			string
+	Algos		map[uint8]AlgoResult
+}
+
+// Minimum probability per algorithm for a sequence to be counted as AMP
+type MinProbs map[uint8]float64
+
+// Whether the algorithm predicted the sequence as AMP. If there is a minimum probability for
+// the algorithm and the result has a probability, then the probability must pass the cutoff,
+// otherwise, the class given by the algorithm is used (e.g. ANN)
+func (ar AlgoResult) IsAMP(algo uint8, minProbs MinProbs) bool {
+
+	minProb, ok := minProbs[algo]
+
+	if ok && ar.HasProb {
+		return ar.Prob >= minProb
+	}
+
+	return ar.Class == AMP
+}
+
+func newSeqResult(idx int) *SeqResult {
+	return &SeqResult{Index: idx, Algos: make(map[uint8]AlgoResult)}
+}
+
+// Results of all the sequences indexed by their position in the input file
+type Results map[int]*SeqResult
+
+// Merge the results of a sequence with the ones already stored (if any)
+func (r Results) Add(sr *SeqResult) {
+
+	curr, ok := r[sr.Index]
+
+	if !ok {
+		r[sr.Index] = sr
+		return
+	}
+
+	for algo, ar := range sr.Algos {
+		curr.Algos[algo] = ar
+	}
+}
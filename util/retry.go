@@ -0,0 +1,149 @@
+package util
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"errors"
+)
+
+const (
+	DEFAULTBACKOFF		= time.Second * 5	// Delay before the first resend
+	DEFAULTMAXBACKOFF	= time.Minute * 5	// Maximum delay between two sends
+	DEFAULTJITTER		= 0.5				// Fraction of the delay that is random
+	DEFAULTBISECTTRIES	= 2					// Tries of each half of a request that keeps failing
+)
+
+// How the requests are resent: the delay grows exponentially (BaseDelay, 2*BaseDelay, 4*BaseDelay...)
+// up to MaxDelay, and a random fraction (Jitter) of it is subtracted, so the concurrent requests
+// do not resend all at once.
+//
+// A request that has failed MaxTries times is splitted in halves, which are sent BisectTries times
+// (and splitted again if they fail), so the sequences that make it fail are found (0 for not splitting)
+type RetryPolicy struct {
+	MaxTries	int
+	BaseDelay	time.Duration
+	MaxDelay	time.Duration
+	Jitter		float64
+	BisectTries	int
+}
+
+// Delay before sending a request again after "tries" failed tries. If the server asked for
+// a delay (Retry-After), then wait at least that
+func (rp RetryPolicy) Delay(tries int, retryAfter time.Duration) time.Duration {
+
+	delay := rp.BaseDelay
+
+	for i := 1; i < tries && delay < rp.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > rp.MaxDelay {
+		delay = rp.MaxDelay
+	}
+
+	if rp.Jitter > 0 {
+		delay -= time.Duration(float64(delay) * rp.Jitter * rand.Float64())
+	}
+
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+
+	return delay
+}
+
+// Error of a try of a request saying whether it is worth to send the request again
+type PredictError struct {
+	Err			error
+	Permanent	bool			// e.g. the file can not be read, or the server rejected the request (4xx)
+	RetryAfter	time.Duration	// Delay asked by the server (429 or 503)
+	Timeout		bool			// The server has not answered in time
+	StatusCode	int				// Of the HTTP response (0 if there was none)
+}
+
+func (e *PredictError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PredictError) Unwrap() error {
+	return e.Err
+}
+
+// Error that is not solved by sending the request again
+func permanentError(err error) error {
+	return &PredictError{Err: err, Permanent: true}
+}
+
+func isPermanent(err error) bool {
+
+	var pe *PredictError
+
+	return errors.As(err, &pe) && pe.Permanent
+}
+
+func retryAfter(err error) time.Duration {
+
+	var pe *PredictError
+
+	if errors.As(err, &pe) {
+		return pe.RetryAfter
+	}
+
+	return 0
+}
+
+// Error of a request that could not be sent or whose response could not be read (the cause),
+// keeping whether it has timed out
+func netError(err, cause error) error {
+
+	var ne net.Error
+
+	return &PredictError{Err: err, Timeout: errors.As(cause, &ne) && ne.Timeout()}
+}
+
+// Classify the error of a HTTP response by its status code: the server errors (5xx), timeouts (408)
+// and too many requests (429) are temporary, any other client error (4xx) is permanent
+func httpStatusError(err error, res *http.Response) error {
+
+	code := res.StatusCode
+
+	switch {
+
+	case code == http.StatusTooManyRequests, code == http.StatusServiceUnavailable:
+		return &PredictError{Err: err, RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")), StatusCode: code}
+
+	case code == http.StatusRequestTimeout, code >= 500:
+		return &PredictError{Err: err, StatusCode: code}
+
+	case code >= 400:
+		return &PredictError{Err: err, Permanent: true, StatusCode: code}
+
+	}
+
+	return &PredictError{Err: err, StatusCode: code}
+}
+
+// The Retry-After header is given in seconds or as a HTTP date
+func parseRetryAfter(value string) time.Duration {
+
+	if value == "" {
+		return 0
+	}
+
+	secs, err := strconv.Atoi(value)
+
+	if err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+
+	t, err := http.ParseTime(value)
+
+	if err == nil && t.After(time.Now()) {
+		return time.Until(t)
+	}
+
+	return 0
+}
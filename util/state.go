@@ -0,0 +1,302 @@
+package util
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Log of the requests finished since the state file was written (one JSON record per line), next to it
+const STATELOGEXT = ".log"
+
+const (
+	STATUSPENDING	= "pending"
+	STATUSDONE		= "done"
+	STATUSFAILED	= "failed"
+)
+
+// State of a splitted file (request) saved in the state file
+type chunkState struct {
+	FileName	string
+	NumSeqs		int
+	IdxFile		int
+	NumSent		int
+	Status		string
+	LastError	string
+	Results		[]*SeqResult
+	FailedSeqs	[]FailedSeq
+}
+
+// Settings of a run that decide its chunks (splitted files) and the results saved for them
+type RunSettings struct {
+	InFile		string
+	InFiles		[]string		// Files of the samples combined in the input file (if more than one)
+	Algos		uint8
+	ChunkSize	int
+	Stream		bool	// The sequences of the chunks are read from the input file, not from the splitted files
+	Translation	bio.Translation	// The input file has the peptides translated from the nucleotide sequences
+	Scanning	bio.Scanning	// The input file has the windows of the sequences
+	Validation	bio.Validation	// The chunks depend on the sequences accepted
+}
+
+// State of a run saved on disk (JSON) after each request has finished, so an interrupted
+// run can be resumed skipping the requests already done
+type JobState struct {
+				RunSettings
+	Rejects		[]bio.Reject	// Sequences with issues found by the validation
+	Chunks		[]*chunkState
+
+	fileName	string
+	mu			sync.Mutex
+	logFile		*os.File
+	logMu		sync.Mutex
+}
+
+func NewJobState(fileName string, settings RunSettings, rejects []bio.Reject, files []bio.FastaFile) *JobState {
+
+	js := &JobState{
+		RunSettings: settings,
+		Rejects: rejects,
+		fileName: fileName,
+	}
+
+	for i, f := range files {
+
+		js.Chunks = append(js.Chunks, &chunkState{
+			FileName: f.FileName,
+			NumSeqs: f.NumSeqs,
+			IdxFile: i + 1,
+			Status: STATUSPENDING,
+		})
+	}
+
+	return js
+}
+
+func LoadJobState(fileName string) (*JobState, error) {
+
+	buff, err := ioutil.ReadFile(fileName)
+
+	if err != nil {
+		return nil, err
+	}
+
+	js := &JobState{fileName: fileName}
+	err = json.Unmarshal(buff, js)
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid state file %s: %s", fileName, err))
+	}
+
+	err = js.replayLog()
+
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid state log %s: %s", js.logName(), err))
+	}
+
+	return js, nil
+}
+
+// Check that the state belongs to a run with the same settings (input file, algorithms, size of the
+// splitted files, mode, translation, scanning and validation). Otherwise, the saved results can not be reused
+func (js *JobState) Check(settings RunSettings) error {
+
+	curr := js.RunSettings
+
+	if curr.InFile != settings.InFile || curr.Algos != settings.Algos || curr.ChunkSize != settings.ChunkSize ||
+			curr.Stream != settings.Stream {
+		return errors.New(fmt.Sprintf(
+			"The state file %s belongs to a different run (input file, algorithms, number of sequences to split or stream mode)",
+			js.fileName))
+	}
+
+	if strings.Join(curr.InFiles, ",") != strings.Join(settings.InFiles, ",") {
+		return errors.New(fmt.Sprintf("The state file %s belongs to a run with other input files: %s",
+			js.fileName, strings.Join(curr.InFiles, ", ")))
+	}
+
+	if curr.Translation != settings.Translation {
+		return errors.New(fmt.Sprintf("The state file %s belongs to a run with other translation: %s",
+			js.fileName, curr.Translation))
+	}
+
+	if curr.Scanning != settings.Scanning {
+		return errors.New(fmt.Sprintf("The state file %s belongs to a run with other scanning: %s",
+			js.fileName, curr.Scanning))
+	}
+
+	if curr.Validation.String() != settings.Validation.String() {
+		return errors.New(fmt.Sprintf("The state file %s belongs to a run with other validation: %s",
+			js.fileName, curr.Validation))
+	}
+
+	// In stream mode, the sequences are read again from the input file
+	if js.Stream {
+		return nil
+	}
+
+	for _, c := range js.Chunks {
+
+		_, err := os.Stat(c.FileName)
+
+		if c.Status != STATUSDONE && err != nil {
+			return errors.New(fmt.Sprintf("Unable to resume file %s: %s", c.FileName, err))
+		}
+	}
+
+	return nil
+}
+
+func (js *JobState) FileName() string {
+	return js.fileName
+}
+
+// Total of files (requests) with the given status
+func (js *JobState) Count(status string) int {
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	tot := 0
+
+	for _, c := range js.Chunks {
+		if c.Status == status {
+			tot++
+		}
+	}
+
+	return tot
+}
+
+// Record a finished request in memory and append it to the log of the state file. The whole state is
+// only written when the run starts and ends (see Save), so every request costs a line, not the whole file
+func (js *JobState) Update(preq *predRequest) error {
+
+	cs := &chunkState{
+		FileName: preq.FileName,
+		NumSeqs: preq.NumSeqs,
+		IdxFile: preq.IdxFile,
+		NumSent: preq.NumSent,
+		Status: preq.Status,
+		LastError: preq.LastError,
+		Results: preq.Results,
+		FailedSeqs: preq.FailedSeqs,
+	}
+
+	js.mu.Lock()
+	js.Chunks[preq.IdxFile - 1] = cs
+	js.mu.Unlock()
+
+	buff, err := json.Marshal(cs)
+
+	if err != nil {
+		return err
+	}
+
+	js.logMu.Lock()
+	defer js.logMu.Unlock()
+
+	if js.logFile == nil {
+
+		js.logFile, err = os.OpenFile(js.logName(), os.O_CREATE | os.O_WRONLY | os.O_APPEND, 0644)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = js.logFile.Write(append(buff, '\n'))
+
+	return err
+}
+
+// Write the whole state and remove the log, whose requests are in it now
+func (js *JobState) Save() error {
+
+	js.logMu.Lock()
+	defer js.logMu.Unlock()
+
+	js.mu.Lock()
+	err := js.save()
+	js.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if js.logFile != nil {
+		js.logFile.Close()
+		js.logFile = nil
+	}
+
+	err = os.Remove(js.logName())
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Write the state in a temporary file and rename it, so an interruption while writting
+// never leaves a broken state file
+func (js *JobState) save() error {
+
+	buff, err := json.Marshal(js)
+
+	if err != nil {
+		return err
+	}
+
+	tmpName := js.fileName + ".tmp"
+	err = ioutil.WriteFile(tmpName, buff, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, js.fileName)
+}
+
+func (js *JobState) logName() string {
+	return js.fileName + STATELOGEXT
+}
+
+// Apply the requests of the log to the state. A record broken by an interruption (the last one) and
+// the ones after it are ignored, so their requests are sent again
+func (js *JobState) replayLog() error {
+
+	buff, err := ioutil.ReadFile(js.logName())
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, line := range bytes.Split(buff, []byte{'\n'}) {
+
+		if len(line) == 0 {
+			continue
+		}
+
+		cs := &chunkState{}
+		err = json.Unmarshal(line, cs)
+
+		if err != nil || cs.IdxFile < 1 || cs.IdxFile > len(js.Chunks) {
+			break
+		}
+
+		js.Chunks[cs.IdxFile - 1] = cs
+	}
+
+	return nil
+}
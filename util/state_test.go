@@ -0,0 +1,112 @@
+package util
+
+import (
+	"bitbucket.org/germelcar/campred/bio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestState(t *testing.T, numFiles int) *JobState {
+
+	dir := t.TempDir()
+	files := []bio.FastaFile{}
+
+	for i := 1; i <= numFiles; i++ {
+		files = append(files, bio.FastaFile{FileName: filepath.Join(dir, fmt.Sprintf("in_%d.fasta", i)),
+			NumSeqs: 2})
+	}
+
+	state := NewJobState(filepath.Join(dir, "in.state.json"), RunSettings{Algos: ALLALGOS, ChunkSize: 2}, nil, files)
+	err := state.Save()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
+
+func finished(state *JobState, idxFile int, status string) *predRequest {
+
+	c := state.Chunks[idxFile - 1]
+
+	return &predRequest{
+		FastaFile: bio.FastaFile{FileName: c.FileName, NumSeqs: c.NumSeqs},
+		IdxFile: idxFile,
+		NumSent: 1,
+		Status: status,
+		Results: []*SeqResult{{Index: idxFile, ID: "seq"}},
+	}
+}
+
+// The finished requests are only appended to the log, which is applied when the state is loaded and
+// removed when the state is written
+func TestStateLog(t *testing.T) {
+
+	tests := []struct {
+		name		string
+		broken		string		// Appended to the log, as an interrupted write
+		save		bool
+		expected	[]string
+	}{
+		{name: "log", expected: []string{STATUSDONE, STATUSFAILED, STATUSPENDING}},
+		{name: "broken last record", broken: `{"FileName": "in_3`,
+			expected: []string{STATUSDONE, STATUSFAILED, STATUSPENDING}},
+		{name: "compacted", save: true, expected: []string{STATUSDONE, STATUSFAILED, STATUSPENDING}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			state := newTestState(t, 3)
+
+			for _, preq := range []*predRequest{finished(state, 1, STATUSDONE), finished(state, 2, STATUSFAILED)} {
+				if err := state.Update(preq); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if tt.broken != "" {
+
+				fout, err := os.OpenFile(state.logName(), os.O_WRONLY | os.O_APPEND, 0644)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				fout.WriteString(tt.broken)
+				fout.Close()
+			}
+
+			if tt.save {
+
+				if err := state.Save(); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := os.Stat(state.logName()); !os.IsNotExist(err) {
+					t.Errorf("Log %s not removed after writting the state", state.logName())
+				}
+			}
+
+			loaded, err := LoadJobState(state.FileName())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, status := range tt.expected {
+
+				if loaded.Chunks[i].Status != status {
+					t.Errorf("File %d: %s, expected %s", i + 1, loaded.Chunks[i].Status, status)
+				}
+			}
+
+			if len(loaded.Chunks[0].Results) != 1 {
+				t.Errorf("File 1: %d results, expected 1", len(loaded.Chunks[0].Results))
+			}
+		})
+	}
+}